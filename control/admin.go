@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// ---------- Admin restart ----------
+// POST /admin/restart {"reason": "..."} shuts the server down exactly as
+// SIGTERM would: in-flight requests finish, then the state file is written
+// and the process exits 0 for the supervisor to restart. Off unless
+// LEGION_ADMIN_RESTART=true, which refuses to start without LEGION_KEY.
+const maxRestartReason = 256
+
+var (
+	adminRestartEnabled bool
+	restartRequests     = make(chan string, 1) // main waits on this next to SIGTERM
+	restarting          atomic.Bool
+)
+
+func adminRestartHandler(w http.ResponseWriter, r *http.Request) {
+	if !adminRestartEnabled {
+		writeError(w, http.StatusNotFound, "not_found", "not found")
+		return
+	}
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+	if apiKey == "" { // config rejects this, but never allow it in dev mode
+		writeError(w, http.StatusForbidden, "forbidden", "admin endpoints need LEGION_KEY")
+		return
+	}
+	if !requireKey(w, r) {
+		return
+	}
+
+	var body struct {
+		Reason string `json:"reason"`
+	}
+	if !decodeJSON(w, r, registerBodyLimit, &body) {
+		return
+	}
+	body.Reason = strings.TrimSpace(body.Reason)
+	if body.Reason == "" || len(body.Reason) > maxRestartReason {
+		writeError(w, http.StatusBadRequest, "bad_request", "reason must be 1..256 bytes")
+		return
+	}
+	if !restarting.CompareAndSwap(false, true) {
+		writeError(w, http.StatusConflict, "restart_pending", "restart already in progress")
+		return
+	}
+
+	log.Printf("admin restart requested from %s (request %s): %q", getPublicIP(r), requestID(r), body.Reason)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"status": "restarting"})
+	restartRequests <- body.Reason
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ---------- Node-count alerts ----------
+// LEGION_ALERT_WEBHOOK gets a JSON POST whenever the online count drops
+// below one of LEGION_ALERT_MIN_ONLINE (comma-separated, e.g. "50,10")
+// and again when it recovers. A crossing has to hold for
+// LEGION_ALERT_DEBOUNCE (default 1m) of monitor sweeps before it fires,
+// so flapping around a threshold stays quiet. Delivery happens on its own
+// goroutine; the monitor only ever does a non-blocking channel send.
+const (
+	defaultAlertDebounce = time.Minute
+	alertQueueSize       = 16
+	alertTimeout         = 5 * time.Second
+)
+
+type AlertPayload struct {
+	Event     string         `json:"event"` // below / recovered
+	Threshold int            `json:"threshold"`
+	Online    int            `json:"online"`
+	Total     int            `json:"total"`
+	ByStatus  map[string]int `json:"by_status"`
+	Time      time.Time      `json:"time"`
+}
+
+type thresholdState struct {
+	min          int
+	below        bool      // last state we alerted on
+	flippedSince time.Time // when the count started disagreeing with below; zero if it agrees
+}
+
+type countAlerter struct {
+	url      string
+	debounce time.Duration
+	states   []thresholdState
+	queue    chan AlertPayload
+	client   *http.Client
+}
+
+// loadAlertConfig returns nil when no webhook is configured.
+func loadAlertConfig() (*countAlerter, error) {
+	url := os.Getenv("LEGION_ALERT_WEBHOOK")
+	if url == "" {
+		return nil, nil
+	}
+	v := os.Getenv("LEGION_ALERT_MIN_ONLINE")
+	if v == "" {
+		return nil, fmt.Errorf("LEGION_ALERT_WEBHOOK needs LEGION_ALERT_MIN_ONLINE")
+	}
+	var mins []int
+	for _, s := range strings.Split(v, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("LEGION_ALERT_MIN_ONLINE must be positive integers, got %q", s)
+		}
+		mins = append(mins, n)
+	}
+	slices.Sort(mins)
+	mins = slices.Compact(mins)
+
+	debounce, err := envDuration("LEGION_ALERT_DEBOUNCE", defaultAlertDebounce)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &countAlerter{
+		url:      url,
+		debounce: debounce,
+		queue:    make(chan AlertPayload, alertQueueSize),
+		client:   &http.Client{Timeout: alertTimeout},
+	}
+	for _, m := range mins {
+		a.states = append(a.states, thresholdState{min: m})
+	}
+	return a, nil
+}
+
+// observe is called by the stale monitor after each sweep. Thresholds
+// start out "not below", so a fleet that boots empty alerts once the
+// debounce passes.
+func (a *countAlerter) observe(now time.Time, s NodeSummary) {
+	online := s.ByStatus["online"]
+	for i := range a.states {
+		st := &a.states[i]
+		below := online < st.min
+		if below == st.below {
+			st.flippedSince = time.Time{}
+			continue
+		}
+		if st.flippedSince.IsZero() {
+			st.flippedSince = now
+		}
+		if now.Sub(st.flippedSince) < a.debounce {
+			continue
+		}
+		st.below, st.flippedSince = below, time.Time{}
+
+		ev := AlertPayload{Event: "recovered", Threshold: st.min, Online: online, Total: s.Total, ByStatus: s.ByStatus, Time: now}
+		if below {
+			ev.Event = "below"
+		}
+		select {
+		case a.queue <- ev:
+		default:
+			log.Printf("alert queue full, dropping %s alert for threshold %d", ev.Event, st.min)
+		}
+	}
+}
+
+// run delivers queued alerts until done is closed.
+func (a *countAlerter) run(done <-chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case ev := <-a.queue:
+			a.send(ev)
+		}
+	}
+}
+
+func (a *countAlerter) send(ev AlertPayload) {
+	body, _ := json.Marshal(ev)
+	resp, err := a.client.Post(a.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("alert webhook: %v", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Printf("alert webhook: %s", resp.Status)
+	}
+}
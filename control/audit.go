@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// ---------- Audit log ----------
+// Bounded, in-memory, appended under the registry lock.
+const defaultAuditSize = 1000
+
+type AuditEvent struct {
+	Time    time.Time `json:"time"`
+	Type    string    `json:"type"` // registered / status / maintenance / drain / command / lease / override / quarantine / deleted / deregistered / evicted / purged / pruned
+	NodeID  string    `json:"node_id"`
+	Details string    `json:"details,omitempty"`
+	From    string    `json:"from,omitempty"` // status events only
+	To      string    `json:"to,omitempty"`
+}
+
+func (r *Registry) auditLocked(typ, nodeID, details string) {
+	r.appendAuditLocked(AuditEvent{Time: r.clock.Now().UTC(), Type: typ, NodeID: nodeID, Details: details})
+}
+
+func (r *Registry) appendAuditLocked(ev AuditEvent) {
+	if len(r.audit) >= r.auditSize {
+		keep := r.auditSize - 1
+		copy(r.audit, r.audit[len(r.audit)-keep:])
+		r.audit = r.audit[:keep]
+	}
+	r.audit = append(r.audit, ev)
+	if r.auditFile != nil {
+		r.auditFile.emit(ev)
+	}
+}
+
+// setStatusLocked is the single place a node changes status, so every
+// transition lands in the audit log, the fleet power total and the usage
+// ledger. Reports whether anything changed.
+//
+// Only the override endpoint calls applyStatusLocked directly.
+func (r *Registry) setStatusLocked(n *NodeRecord, status string) bool {
+	if n.StatusOverride {
+		return false // a manual status wins until cleared, see override.go
+	}
+	return r.applyStatusLocked(n, status)
+}
+
+func (r *Registry) applyStatusLocked(n *NodeRecord, status string) bool {
+	if n.Status == status {
+		return false
+	}
+	now := r.clock.Now().UTC()
+	if n.Status != "" {
+		r.appendAuditLocked(AuditEvent{
+			Time:    now,
+			Type:    "status",
+			NodeID:  n.NodeID,
+			Details: n.Status + " -> " + status,
+			From:    n.Status,
+			To:      status,
+		})
+	}
+	r.accrueUsageLocked(n, now)
+	r.fleetPowerW -= powerContribution(n)
+	n.Status = status
+	n.StatusSince = now
+	n.modified = now
+	r.fleetPowerW += powerContribution(n)
+	return true
+}
+
+// Events returns audit entries strictly after since, oldest first.
+func (r *Registry) Events(since time.Time) []AuditEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	out := []AuditEvent{}
+	for _, ev := range r.audit {
+		if ev.Time.After(since) {
+			out = append(out, ev)
+		}
+	}
+	return out
+}
+
+// GET /events[?since=RFC3339]
+func eventsHandler(reg *Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		if !requireKey(w, r) {
+			return
+		}
+		var since time.Time
+		if v := r.URL.Query().Get("since"); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "bad_request", "since must be RFC3339")
+				return
+			}
+			since = t
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(reg.Events(since))
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestReregistrationIsAudited(t *testing.T) {
+	_, clock, h := newTestServer(t)
+	req := testRegisterRequest("again", "10.0.0.1")
+	id := registerNode(t, h, req).NodeID
+	clock.Advance(time.Minute)
+	if again := registerNode(t, h, req).NodeID; again != id {
+		t.Fatalf("re-register got node %s, want %s", again, id)
+	}
+
+	rec := doRequest(t, h, http.MethodGet, "/events", nil, nil)
+	var events []AuditEvent
+	if err := json.NewDecoder(rec.Body).Decode(&events); err != nil {
+		t.Fatalf("decode events: %v", err)
+	}
+	var details []string
+	for _, ev := range events {
+		if ev.Type == "registered" && ev.NodeID == id {
+			details = append(details, ev.Details)
+		}
+	}
+	want := []string{"again 10.0.0.1", "re-registered again 10.0.0.1"}
+	if !slices.Equal(details, want) {
+		t.Fatalf("registered events %q, want %q", details, want)
+	}
+}
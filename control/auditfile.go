@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync/atomic"
+)
+
+// ---------- Audit file ----------
+// With LEGION_AUDIT_FILE set, every audit event is also appended to that
+// file as a JSON line. Events go through a buffered channel to a single
+// writer goroutine so auditLocked never waits on disk; if the buffer is
+// full the event is dropped from the file (it is still in memory) and
+// counted. The file rolls at LEGION_AUDIT_FILE_MAX_MB, keeping
+// LEGION_AUDIT_FILE_KEEP old copies as path.1 (newest) .. path.N.
+const (
+	defaultAuditFileMaxMB = 10
+	defaultAuditFileKeep  = 5
+	auditQueueSize        = 1024
+)
+
+type auditFile struct {
+	w       *rotatingWriter
+	queue   chan AuditEvent
+	dropped atomic.Int64
+	stopped chan struct{}
+}
+
+// loadAuditFileConfig returns nil when LEGION_AUDIT_FILE is unset.
+func loadAuditFileConfig() (*auditFile, error) {
+	path := os.Getenv("LEGION_AUDIT_FILE")
+	if path == "" {
+		return nil, nil
+	}
+	maxMB, err := envPositiveInt("LEGION_AUDIT_FILE_MAX_MB", defaultAuditFileMaxMB)
+	if err != nil {
+		return nil, err
+	}
+	keep, err := envPositiveInt("LEGION_AUDIT_FILE_KEEP", defaultAuditFileKeep)
+	if err != nil {
+		return nil, err
+	}
+	w, err := openRotatingWriter(path, int64(maxMB)<<20, keep)
+	if err != nil {
+		return nil, fmt.Errorf("LEGION_AUDIT_FILE: %v", err)
+	}
+	return &auditFile{
+		w:       w,
+		queue:   make(chan AuditEvent, auditQueueSize),
+		stopped: make(chan struct{}),
+	}, nil
+}
+
+// emit never blocks; called with the registry lock held.
+func (a *auditFile) emit(ev AuditEvent) {
+	select {
+	case a.queue <- ev:
+	default:
+		a.dropped.Add(1)
+	}
+}
+
+// run writes events until done is closed, then drains what's queued and
+// closes the file. Wait on stopped before exiting the process.
+func (a *auditFile) run(done <-chan struct{}) {
+	defer close(a.stopped)
+	defer a.w.Close()
+	for {
+		select {
+		case ev := <-a.queue:
+			a.write(ev)
+		case <-done:
+			for {
+				select {
+				case ev := <-a.queue:
+					a.write(ev)
+				default:
+					if n := a.dropped.Load(); n > 0 {
+						log.Printf("audit file: %d events dropped (queue full)", n)
+					}
+					return
+				}
+			}
+		}
+	}
+}
+
+func (a *auditFile) write(ev AuditEvent) {
+	line, _ := json.Marshal(ev)
+	if _, err := a.w.Write(append(line, '\n')); err != nil {
+		log.Printf("audit file: %v", err)
+	}
+}
+
+// rotatingWriter appends to path and rolls it once it would exceed max
+// bytes. Only the audit goroutine uses it, so there is no locking.
+type rotatingWriter struct {
+	path string
+	max  int64
+	keep int
+	f    *os.File
+	size int64
+}
+
+func openRotatingWriter(path string, max int64, keep int) (*rotatingWriter, error) {
+	w := &rotatingWriter{path: path, max: max, keep: keep}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *rotatingWriter) open() error {
+	f, err := os.OpenFile(w.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.f, w.size = f, st.Size()
+	return nil
+}
+
+func (w *rotatingWriter) Write(p []byte) (int, error) {
+	if w.size > 0 && w.size+int64(len(p)) > w.max {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.f.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// rotate shifts path.N-1 -> path.N ... path -> path.1, dropping the oldest.
+func (w *rotatingWriter) rotate() error {
+	if err := w.f.Close(); err != nil {
+		return err
+	}
+	os.Remove(fmt.Sprintf("%s.%d", w.path, w.keep))
+	for i := w.keep - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", w.path, i), fmt.Sprintf("%s.%d", w.path, i+1))
+	}
+	if err := os.Rename(w.path, w.path+".1"); err != nil {
+		return err
+	}
+	return w.open()
+}
+
+func (w *rotatingWriter) Close() error { return w.f.Close() }
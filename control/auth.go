@@ -0,0 +1,46 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/http"
+)
+
+// ---------- Per-node tokens ----------
+// /register (shared LEGION_KEY) issues each node its own token; only a
+// hash is kept. The agent then authenticates heartbeats with it, so one
+// leaked agent can't impersonate the rest of the fleet.
+const nodeTokenHeader = "X-LEGION-NODE-TOKEN"
+
+var errBadToken = errors.New("invalid node token")
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// VerifyToken checks token against the node's stored hash.
+func (r *Registry) VerifyToken(id, token string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n, ok := r.nodes[id]
+	if !ok {
+		return errUnknownNode
+	}
+	if token == "" || subtle.ConstantTimeCompare([]byte(hashToken(token)), []byte(n.tokenHash)) != 1 {
+		return errBadToken
+	}
+	return nil
+}
+
+// nodeTokenOK is the requireKey counterpart for agent calls made after
+// registration. Unknown nodes pass so the caller can answer 404 instead.
+func nodeTokenOK(reg *Registry, r *http.Request, id string) bool {
+	if apiKey == "" {
+		return true // dev mode
+	}
+	return !errors.Is(reg.VerifyToken(id, r.Header.Get(nodeTokenHeader)), errBadToken)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+)
+
+// ---------- Fleet capacity ----------
+type CapacityTotals struct {
+	Nodes        int `json:"nodes"`
+	JobsParallel int `json:"jobs_parallel"`
+	JobsFree     int `json:"jobs_free"`
+	RAMGB        int `json:"ram_gb"`
+	VRAMGB       int `json:"vram_gb"`
+	Cores        int `json:"cores"`
+	PowerW       int `json:"power_w"`
+}
+
+func (t *CapacityTotals) add(n *NodeRecord) {
+	t.Nodes++
+	t.JobsParallel += n.Capacity.JobsParallel
+	t.JobsFree += max(0, freeSlots(n))
+	t.RAMGB += n.RAMGB
+	t.Cores += n.CPU.Cores
+	t.PowerW += n.PowerW
+	for _, g := range n.GPU {
+		t.VRAMGB += g.VRAMGB
+	}
+}
+
+// Available counts online nodes not in maintenance; Total (on request)
+// counts everything.
+type CapacityReport struct {
+	Available CapacityTotals  `json:"available"`
+	Total     *CapacityTotals `json:"total,omitempty"`
+	Power     *PowerBudget    `json:"power,omitempty"` // only with LEGION_POWER_BUDGET_W
+}
+
+// schedulable mirrors what the scheduler will consider at all.
+func schedulable(n *NodeRecord) bool {
+	return n.Status == "online" && !n.Maintenance
+}
+
+func (r *Registry) Capacity(includeTotal bool) CapacityReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var rep CapacityReport
+	var total CapacityTotals
+	for _, n := range r.nodes {
+		total.add(n)
+		if schedulable(n) {
+			rep.Available.add(n)
+		}
+	}
+	if includeTotal {
+		rep.Total = &total
+	}
+	rep.Power = r.powerBudgetLocked()
+	return rep
+}
+
+// GET /capacity[?include_total=true]
+func capacityHandler(reg *Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		if !requireKey(w, r) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(reg.Capacity(r.URL.Query().Get("include_total") == "true"))
+	}
+}
+
+// ---------- Fleet summary ----------
+// Counts keyed by the raw values agents reported.
+type NodeSummary struct {
+	Total    int            `json:"total"`
+	ByOS     map[string]int `json:"by_os"`
+	ByArch   map[string]int `json:"by_arch"`
+	ByStatus map[string]int `json:"by_status"`
+}
+
+func (r *Registry) Summary() NodeSummary {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := NodeSummary{
+		Total:    len(r.nodes),
+		ByOS:     map[string]int{},
+		ByArch:   map[string]int{},
+		ByStatus: map[string]int{},
+	}
+	for _, n := range r.nodes {
+		s.ByOS[n.OS]++
+		s.ByArch[n.Arch]++
+		s.ByStatus[n.Status]++
+	}
+	return s
+}
+
+// GET /nodes/summary
+func summaryHandler(reg *Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		if !requireKey(w, r) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(reg.Summary())
+	}
+}
+
+// ---------- Groups ----------
+type GroupSummary struct {
+	Name      string         `json:"name"`
+	Members   int            `json:"members"`
+	Available CapacityTotals `json:"available"`
+}
+
+// Groups partitions the capacity view by node group; ungrouped nodes are left out.
+func (r *Registry) Groups() []GroupSummary {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	byName := map[string]*GroupSummary{}
+	for _, n := range r.nodes {
+		if n.Group == "" {
+			continue
+		}
+		g, ok := byName[n.Group]
+		if !ok {
+			g = &GroupSummary{Name: n.Group}
+			byName[n.Group] = g
+		}
+		g.Members++
+		if schedulable(n) {
+			g.Available.add(n)
+		}
+	}
+
+	out := make([]GroupSummary, 0, len(byName))
+	for _, g := range byName {
+		out = append(out, *g)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// GET /groups
+func groupsHandler(reg *Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		if !requireKey(w, r) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(reg.Groups())
+	}
+}
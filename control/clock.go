@@ -0,0 +1,14 @@
+package main
+
+import "time"
+
+// ---------- Clock ----------
+// Clock abstracts time.Now so staleness and heartbeat timing can be
+// driven deterministically.
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
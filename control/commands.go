@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ---------- Command queue ----------
+// Operators queue commands ("upgrade", "reboot", "collect-logs", ...) per
+// node. Each is handed out in the next heartbeat reply, then waits for
+// the agent to ACK it by ID via /agent/command/ack. One still unacked
+// after commandAckTimeout goes out again, up to maxCommandDeliveries
+// times, and is then dropped, so a lost reply can't wedge the queue.
+const (
+	maxQueuedCommands    = 32
+	maxCommandLen        = 64
+	maxCommandDeliveries = 3
+	commandAckTimeout    = 5 * time.Minute
+)
+
+var (
+	errQueueFull      = errors.New("command queue full")
+	errUnknownCommand = errors.New("unknown command_id")
+)
+
+type NodeCommand struct {
+	ID          string            `json:"id"`
+	Command     string            `json:"command"`
+	Args        map[string]string `json:"args,omitempty"`
+	CreatedAt   time.Time         `json:"created_at"`
+	DeliveredAt *time.Time        `json:"delivered_at,omitempty"`
+	Deliveries  int               `json:"deliveries,omitempty"`
+}
+
+// EnqueueCommand adds cmd to the node's queue. Delivered-but-unacked
+// commands count against the limit too, until they expire.
+func (r *Registry) EnqueueCommand(id, command string, args map[string]string) (NodeCommand, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n, ok := r.nodes[id]
+	if !ok {
+		return NodeCommand{}, errUnknownNode
+	}
+	r.expireCommandsLocked(n, r.clock.Now().UTC())
+	if len(n.commands) >= maxQueuedCommands {
+		return NodeCommand{}, errQueueFull
+	}
+	cmdID, err := randomID(8)
+	if err != nil {
+		return NodeCommand{}, err
+	}
+	cmd := NodeCommand{
+		ID:        cmdID,
+		Command:   command,
+		Args:      args,
+		CreatedAt: r.clock.Now().UTC(),
+	}
+	n.commands = append(n.commands, cmd)
+	r.auditLocked("command", id, cmd.ID+" queued: "+command)
+	return cmd, nil
+}
+
+// DeliverCommands returns the node's undelivered commands, plus any whose
+// ACK timed out, and marks them delivered.
+func (r *Registry) DeliverCommands(id string) []NodeCommand {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n, ok := r.nodes[id]
+	if !ok {
+		return nil
+	}
+	now := r.clock.Now().UTC()
+	r.expireCommandsLocked(n, now)
+	var out []NodeCommand
+	for i := range n.commands {
+		c := &n.commands[i]
+		if c.DeliveredAt != nil && now.Sub(*c.DeliveredAt) < commandAckTimeout {
+			continue
+		}
+		c.DeliveredAt = &now
+		c.Deliveries++
+		out = append(out, *c)
+	}
+	return out
+}
+
+// expireCommandsLocked drops commands that used up their deliveries
+// without an ACK.
+func (r *Registry) expireCommandsLocked(n *NodeRecord, now time.Time) {
+	kept := n.commands[:0]
+	for _, c := range n.commands {
+		if c.Deliveries >= maxCommandDeliveries && now.Sub(*c.DeliveredAt) >= commandAckTimeout {
+			r.auditLocked("command", n.NodeID, c.ID+" "+c.Command+" expired unacked")
+			continue
+		}
+		kept = append(kept, c)
+	}
+	n.commands = kept
+}
+
+// AckCommand drops a finished command from the queue and audits the outcome.
+func (r *Registry) AckCommand(id, cmdID string, success bool, output string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n, ok := r.nodes[id]
+	if !ok {
+		return errUnknownNode
+	}
+	for i, c := range n.commands {
+		if c.ID != cmdID {
+			continue
+		}
+		n.commands = append(n.commands[:i], n.commands[i+1:]...)
+		result := "ok"
+		if !success {
+			result = "failed"
+		}
+		if output != "" {
+			result += ": " + output
+		}
+		r.auditLocked("command", id, cmdID+" "+c.Command+" "+result)
+		return nil
+	}
+	return errUnknownCommand
+}
+
+// POST /nodes/{id}/command {"command": "upgrade", "args": {"version": "1.4.0"}}
+func enqueueCommandHandler(reg *Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+		if !requireKey(w, r) {
+			return
+		}
+		id := r.PathValue("id")
+		logNodeID(r, id)
+
+		var body struct {
+			Command string            `json:"command"`
+			Args    map[string]string `json:"args"`
+		}
+		if !decodeJSON(w, r, heartbeatBodyLimit, &body) {
+			return
+		}
+		body.Command = strings.TrimSpace(body.Command)
+		if body.Command == "" || len(body.Command) > maxCommandLen {
+			writeError(w, http.StatusBadRequest, "bad_request", "command must be 1..64 bytes")
+			return
+		}
+
+		cmd, err := reg.EnqueueCommand(id, body.Command, body.Args)
+		switch {
+		case errors.Is(err, errUnknownNode):
+			writeErrorFor(w, http.StatusNotFound, err)
+			return
+		case errors.Is(err, errQueueFull):
+			writeErrorFor(w, http.StatusConflict, err)
+			return
+		case err != nil:
+			log.Printf("enqueue command: %v", err)
+			writeError(w, http.StatusInternalServerError, "internal", "internal error")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(cmd)
+	}
+}
+
+// POST /agent/command/ack {"node_id", "command_id", "success", "output"}
+func ackCommandHandler(reg *Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+
+		var body struct {
+			NodeID    string `json:"node_id"`
+			CommandID string `json:"command_id"`
+			Success   bool   `json:"success"`
+			Output    string `json:"output"`
+		}
+		if !decodeJSON(w, r, heartbeatBodyLimit, &body) {
+			return
+		}
+		if body.NodeID == "" || body.CommandID == "" {
+			writeError(w, http.StatusBadRequest, "bad_request", "node_id and command_id required")
+			return
+		}
+		logNodeID(r, body.NodeID)
+		if !nodeTokenOK(reg, r, body.NodeID) {
+			writeError(w, http.StatusUnauthorized, "unauthorized", "unauthorized")
+			return
+		}
+
+		if err := reg.AckCommand(body.NodeID, body.CommandID, body.Success, body.Output); err != nil {
+			writeErrorFor(w, http.StatusNotFound, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
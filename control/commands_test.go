@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func heartbeatCommands(t *testing.T, h http.Handler, id string) []NodeCommand {
+	t.Helper()
+	rec := doRequest(t, h, http.MethodPost, "/agent/heartbeat", AgentHeartbeat{NodeID: id}, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("heartbeat: status %d: %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		Commands []NodeCommand `json:"commands"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode heartbeat: %v", err)
+	}
+	return resp.Commands
+}
+
+func TestUnackedCommandsRedeliverThenExpire(t *testing.T) {
+	_, clock, h := newTestServer(t)
+	id := registerNode(t, h, testRegisterRequest("cmd", "10.0.0.1")).NodeID
+	enqueue := func() int {
+		return doRequest(t, h, http.MethodPost, "/nodes/"+id+"/command", map[string]string{"command": "reboot"}, nil).Code
+	}
+	for range maxQueuedCommands {
+		if code := enqueue(); code != http.StatusOK {
+			t.Fatalf("enqueue: status %d", code)
+		}
+	}
+	if code := enqueue(); code != http.StatusConflict {
+		t.Fatalf("enqueue past the cap: status %d, want 409", code)
+	}
+
+	for i := 1; i <= maxCommandDeliveries; i++ {
+		cmds := heartbeatCommands(t, h, id)
+		if len(cmds) != maxQueuedCommands || cmds[0].Deliveries != i {
+			t.Fatalf("delivery %d: got %d commands (deliveries %d)", i, len(cmds), cmds[0].Deliveries)
+		}
+		if again := heartbeatCommands(t, h, id); len(again) != 0 {
+			t.Fatalf("delivery %d: %d commands resent before the ack timeout", i, len(again))
+		}
+		clock.Advance(commandAckTimeout)
+	}
+
+	if cmds := heartbeatCommands(t, h, id); len(cmds) != 0 {
+		t.Fatalf("got %d commands past max deliveries, want them expired", len(cmds))
+	}
+	if code := enqueue(); code != http.StatusOK {
+		t.Fatalf("enqueue after expiry: status %d, want 200", code)
+	}
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ---------- Config ----------
+// envPositiveInt reads name as a positive integer, returning def when unset.
+func envPositiveInt(name string, def int) (int, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("%s must be a positive integer, got %q", name, v)
+	}
+	return n, nil
+}
+
+// envBool reads name as a bool ("true", "1", ...), returning def when unset.
+func envBool(name string, def bool) (bool, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return def, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("%s must be true or false, got %q", name, v)
+	}
+	return b, nil
+}
+
+// envDuration reads name as a Go duration ("72h"), returning def when unset.
+func envDuration(name string, def time.Duration) (time.Duration, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative duration like 72h, got %q", name, v)
+	}
+	return d, nil
+}
+
+// Config is the core server settings. Load starts from defaults, applies
+// the JSON file named by LEGION_CONFIG and then any env vars, so env always
+// wins over the file. Durations are Go strings ("72h") in the file.
+type Config struct {
+	ListenAddr string `json:"listen_addr"`
+	Key        string `json:"key"`
+	RequireKey bool   `json:"require_key"`
+
+	AdminRestart bool `json:"admin_restart"` // enables POST /admin/restart
+
+	HeartbeatSec      int           `json:"heartbeat_sec"`
+	StaleMultiplier   int           `json:"stale_multiplier"`
+	OfflineMultiplier int           `json:"offline_multiplier"`
+	PurgeAfter        jsonDuration  `json:"purge_after"`        // 0 = never
+	RegisterGrace     jsonDuration  `json:"register_grace"`     // 0 = 1.5 heartbeats
+	HeartbeatDebounce *jsonDuration `json:"heartbeat_debounce"` // unset = min(1s, half a heartbeat)
+
+	TLSCert   string `json:"tls_cert"`
+	TLSKey    string `json:"tls_key"`
+	StateFile string `json:"state_file"`
+}
+
+type jsonDuration time.Duration
+
+func (d *jsonDuration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"72h\"")
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = jsonDuration(v)
+	return nil
+}
+
+func defaultConfig() Config {
+	return Config{
+		ListenAddr:        ":8081",
+		HeartbeatSec:      defaultHeartbeatSec,
+		StaleMultiplier:   defaultStaleMultiplier,
+		OfflineMultiplier: defaultOfflineMultiplier,
+	}
+}
+
+// Load returns the validated config; see Config for precedence.
+func Load() (Config, error) {
+	cfg := defaultConfig()
+	if path := os.Getenv("LEGION_CONFIG"); path != "" {
+		if err := cfg.readFile(path); err != nil {
+			return cfg, fmt.Errorf("LEGION_CONFIG: %v", err)
+		}
+	}
+	if err := cfg.applyEnv(); err != nil {
+		return cfg, err
+	}
+	return cfg, cfg.validate()
+}
+
+func (c *Config) readFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields() // catch typos instead of silently using defaults
+	return dec.Decode(c)
+}
+
+func (c *Config) applyEnv() error {
+	var err error
+	if v := os.Getenv("LEGION_LISTEN_ADDR"); v != "" {
+		c.ListenAddr = v
+	} else if os.Getenv("LEGION_PORT") != "" {
+		// shorthand when only the port matters
+		port, err := envPositiveInt("LEGION_PORT", 0)
+		if err != nil {
+			return err
+		}
+		c.ListenAddr = fmt.Sprintf(":%d", port)
+	}
+	if v := os.Getenv("LEGION_KEY"); v != "" {
+		c.Key = v
+	}
+	if c.RequireKey, err = envBool("LEGION_REQUIRE_KEY", c.RequireKey); err != nil {
+		return err
+	}
+	if c.AdminRestart, err = envBool("LEGION_ADMIN_RESTART", c.AdminRestart); err != nil {
+		return err
+	}
+	if c.HeartbeatSec, err = envPositiveInt("LEGION_HEARTBEAT_SEC", c.HeartbeatSec); err != nil {
+		return err
+	}
+	if c.StaleMultiplier, err = envPositiveInt("LEGION_STALE_MULTIPLIER", c.StaleMultiplier); err != nil {
+		return err
+	}
+	if c.OfflineMultiplier, err = envPositiveInt("LEGION_OFFLINE_MULTIPLIER", c.OfflineMultiplier); err != nil {
+		return err
+	}
+	purge, err := envDuration("LEGION_PURGE_AFTER", time.Duration(c.PurgeAfter))
+	if err != nil {
+		return err
+	}
+	grace, err := envDuration("LEGION_REGISTER_GRACE", time.Duration(c.RegisterGrace))
+	if err != nil {
+		return err
+	}
+	c.PurgeAfter, c.RegisterGrace = jsonDuration(purge), jsonDuration(grace)
+	if os.Getenv("LEGION_HEARTBEAT_DEBOUNCE") != "" {
+		debounce, err := envDuration("LEGION_HEARTBEAT_DEBOUNCE", 0)
+		if err != nil {
+			return err
+		}
+		d := jsonDuration(debounce)
+		c.HeartbeatDebounce = &d
+	}
+	if v := os.Getenv("LEGION_TLS_CERT"); v != "" {
+		c.TLSCert = v
+	}
+	if v := os.Getenv("LEGION_TLS_KEY"); v != "" {
+		c.TLSKey = v
+	}
+	if v := os.Getenv("LEGION_STATE_FILE"); v != "" {
+		c.StateFile = v
+	}
+	return nil
+}
+
+func (c *Config) validate() error {
+	if _, _, err := net.SplitHostPort(c.ListenAddr); err != nil {
+		return fmt.Errorf("listen address: %v", err)
+	}
+	if c.HeartbeatSec <= 0 || c.StaleMultiplier <= 0 || c.OfflineMultiplier <= 0 {
+		return fmt.Errorf("heartbeat interval and multipliers must be positive")
+	}
+	if c.OfflineMultiplier <= c.StaleMultiplier {
+		return fmt.Errorf("offline multiplier (%d) must exceed stale multiplier (%d)", c.OfflineMultiplier, c.StaleMultiplier)
+	}
+	if c.PurgeAfter < 0 || c.RegisterGrace < 0 || c.heartbeatDebounce() < 0 {
+		return fmt.Errorf("purge_after, register_grace and heartbeat_debounce must not be negative")
+	}
+	// only an operator-set debounce can be wrong; the derived one always fits
+	if c.HeartbeatDebounce != nil && c.heartbeatDebounce() >= time.Duration(c.HeartbeatSec)*time.Second {
+		return fmt.Errorf("heartbeat debounce must be shorter than the heartbeat interval")
+	}
+	// setting only one TLS path is a mistake worth failing on
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		return fmt.Errorf("TLS cert and key must be set together")
+	}
+	if c.Key == "" && c.RequireKey {
+		return fmt.Errorf("key is empty but require_key is set")
+	}
+	if c.Key == "" && c.AdminRestart {
+		return fmt.Errorf("admin_restart needs a key")
+	}
+	return nil
+}
+
+// heartbeatDebounce is the configured debounce, or min(1s, interval/2).
+func (c Config) heartbeatDebounce() time.Duration {
+	if c.HeartbeatDebounce != nil {
+		return time.Duration(*c.HeartbeatDebounce)
+	}
+	return min(defaultHeartbeatDebounce, time.Duration(c.HeartbeatSec)*time.Second/2)
+}
+
+// apiKey is the shared LEGION_KEY; empty means dev mode.
+var apiKey string
+
+// apply installs c on reg and in the package-level auth settings.
+func (c Config) apply(reg *Registry) {
+	hb := time.Duration(c.HeartbeatSec) * time.Second
+	reg.heartbeatInterval = c.HeartbeatSec
+	reg.staleAfter = time.Duration(c.StaleMultiplier) * hb
+	reg.offlineAfter = time.Duration(c.OfflineMultiplier) * hb
+	reg.purgeAfter = time.Duration(c.PurgeAfter)
+	reg.registerGrace = time.Duration(c.RegisterGrace)
+	reg.heartbeatDebounce = c.heartbeatDebounce()
+	if reg.registerGrace == 0 {
+		reg.registerGrace = hb * 3 / 2
+	}
+	apiKey = c.Key
+	adminRestartEnabled = c.AdminRestart
+	if apiKey == "" {
+		log.Printf("WARNING: LEGION_KEY is not set; every endpoint is open (dev mode). Set LEGION_REQUIRE_KEY=true in production.")
+	}
+}
+
+// loadHistoryConfig reads LEGION_POWER_HISTORY_SIZE and LEGION_AUDIT_SIZE.
+func loadHistoryConfig(reg *Registry) error {
+	size, err := envPositiveInt("LEGION_POWER_HISTORY_SIZE", defaultPowerHistorySize)
+	if err != nil {
+		return err
+	}
+	auditSize, err := envPositiveInt("LEGION_AUDIT_SIZE", defaultAuditSize)
+	if err != nil {
+		return err
+	}
+	reg.powerHistorySize = size
+	reg.auditSize = auditSize
+	return nil
+}
+
+// loadMonitorInterval reads LEGION_MONITOR_INTERVAL, the stale sweep period.
+func loadMonitorInterval() (time.Duration, error) {
+	d, err := envDuration("LEGION_MONITOR_INTERVAL", defaultMonitorInterval)
+	if err != nil {
+		return 0, err
+	}
+	if d == 0 {
+		return 0, fmt.Errorf("LEGION_MONITOR_INTERVAL must be greater than zero")
+	}
+	return d, nil
+}
+
+// loadPowerBudgetConfig reads LEGION_POWER_BUDGET_W (unset = no budget).
+func loadPowerBudgetConfig(reg *Registry) error {
+	budget, err := envPositiveInt("LEGION_POWER_BUDGET_W", 0)
+	if err != nil {
+		return err
+	}
+	reg.powerBudgetW = budget
+	return nil
+}
+
+// loadRegistryLimitConfig reads LEGION_MAX_NODES, LEGION_FULL_POLICY
+// ("reject", the default, or "evict") and LEGION_UNIQUE_HOSTNAMES.
+func loadRegistryLimitConfig(reg *Registry) error {
+	maxNodes, err := envPositiveInt("LEGION_MAX_NODES", 0)
+	if err != nil {
+		return err
+	}
+	switch p := os.Getenv("LEGION_FULL_POLICY"); p {
+	case "", "reject":
+	case "evict":
+		reg.evictOnFull = true
+	default:
+		return fmt.Errorf("LEGION_FULL_POLICY must be reject or evict, got %q", p)
+	}
+	unique, err := envBool("LEGION_UNIQUE_HOSTNAMES", false)
+	if err != nil {
+		return err
+	}
+	reg.maxNodes = maxNodes
+	reg.uniqueHostnames = unique
+	return nil
+}
+
+// loadLeaseConfig reads LEGION_UNLEASED_RESERVE: when true, nodes without
+// an active lease can be reserved without one (see lease.go).
+func loadLeaseConfig(reg *Registry) error {
+	unleased, err := envBool("LEGION_UNLEASED_RESERVE", false)
+	if err != nil {
+		return err
+	}
+	reg.unleasedReserve = unleased
+	return nil
+}
+
+// loadBodyLimitConfig reads LEGION_MAX_REGISTER_BYTES and LEGION_MAX_HEARTBEAT_BYTES.
+func loadBodyLimitConfig() error {
+	reg, err := envPositiveInt("LEGION_MAX_REGISTER_BYTES", int(registerBodyLimit))
+	if err != nil {
+		return err
+	}
+	hb, err := envPositiveInt("LEGION_MAX_HEARTBEAT_BYTES", int(heartbeatBodyLimit))
+	if err != nil {
+		return err
+	}
+	registerBodyLimit, heartbeatBodyLimit = int64(reg), int64(hb)
+	return nil
+}
+
+// loadTrustedProxies parses LEGION_TRUSTED_PROXIES, a comma-separated list
+// of CIDRs or bare IPs, e.g. "10.0.0.0/8,192.168.1.5".
+func loadTrustedProxies() error {
+	v := os.Getenv("LEGION_TRUSTED_PROXIES")
+	if v == "" {
+		return nil
+	}
+	var nets []*net.IPNet
+	for _, s := range strings.Split(v, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return fmt.Errorf("LEGION_TRUSTED_PROXIES: bad address %q", s)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return fmt.Errorf("LEGION_TRUSTED_PROXIES: %v", err)
+		}
+		nets = append(nets, n)
+	}
+	trustedProxies = nets
+	return nil
+}
+
+// loadRateLimitConfig reads LEGION_RATE_PER_MIN and LEGION_RATE_BURST.
+func loadRateLimitConfig() (perMin, burst int, err error) {
+	perMin, err = envPositiveInt("LEGION_RATE_PER_MIN", defaultRatePerMin)
+	if err != nil {
+		return 0, 0, err
+	}
+	burst, err = envPositiveInt("LEGION_RATE_BURST", defaultRateBurst)
+	if err != nil {
+		return 0, 0, err
+	}
+	return perMin, burst, nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHeartbeatDebounceDefault(t *testing.T) {
+	t.Setenv("LEGION_HEARTBEAT_SEC", "1")
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("LEGION_HEARTBEAT_SEC=1 rejected: %v", err)
+	}
+	if got := cfg.heartbeatDebounce(); got != 500*time.Millisecond {
+		t.Fatalf("derived debounce %s, want 500ms", got)
+	}
+
+	t.Setenv("LEGION_HEARTBEAT_SEC", "10")
+	if cfg, err = Load(); err != nil || cfg.heartbeatDebounce() != time.Second {
+		t.Fatalf("10s interval: debounce %s, err %v; want 1s", cfg.heartbeatDebounce(), err)
+	}
+
+	// an explicit debounce that doesn't fit is still an error
+	t.Setenv("LEGION_HEARTBEAT_SEC", "1")
+	t.Setenv("LEGION_HEARTBEAT_DEBOUNCE", "1s")
+	if _, err := Load(); err == nil {
+		t.Fatal("debounce equal to the interval accepted")
+	}
+	t.Setenv("LEGION_HEARTBEAT_DEBOUNCE", "0")
+	if cfg, err = Load(); err != nil || cfg.heartbeatDebounce() != 0 {
+		t.Fatalf("debounce 0: got %s, err %v; want off", cfg.heartbeatDebounce(), err)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sort"
+	"time"
+)
+
+// ---------- Cost report ----------
+// The registry keeps a usage ledger: per node, the stretches it spent
+// online (or draining), each stamped with the cost center and price per
+// hour in effect then. Spans are booked on every status change, before a
+// re-registration changes the rate, and before a record is removed, so
+// deleting, pruning or evicting a node doesn't erase what it already ran
+// up and repricing doesn't touch the past. The ledger is saved with the
+// state file; spans that ended over usageRetention ago are dropped.
+const (
+	unassignedCostCenter = "unassigned"
+	usageRetention       = 31 * 24 * time.Hour
+)
+
+// usageSpan is one unbroken live stretch at a single rate.
+type usageSpan struct {
+	CostCenter   string    `json:"cost_center,omitempty"`
+	PricePerHour float64   `json:"price_per_hour,omitempty"`
+	From         time.Time `json:"from"`
+	Until        time.Time `json:"until"`
+}
+
+// accrueUsageLocked books the time since the last call if n was live
+// throughout it. The sweep calls it too, so the saved ledger stays current.
+func (r *Registry) accrueUsageLocked(n *NodeRecord, now time.Time) {
+	if now.Before(n.accruedAt) {
+		return
+	}
+	if liveStatusKind(n.Status) && !n.accruedAt.IsZero() && now.After(n.accruedAt) {
+		spans := r.usage[n.NodeID]
+		if k := len(spans); k > 0 && spans[k-1].Until.Equal(n.accruedAt) &&
+			spans[k-1].CostCenter == n.CostCenter && spans[k-1].PricePerHour == n.PricePerHour {
+			spans[k-1].Until = now
+		} else {
+			spans = append(spans, usageSpan{CostCenter: n.CostCenter, PricePerHour: n.PricePerHour, From: n.accruedAt, Until: now})
+		}
+		r.usage[n.NodeID] = spans
+	}
+	n.accruedAt = now
+}
+
+// usageExpireLocked runs from the sweep.
+func (r *Registry) usageExpireLocked(now time.Time) {
+	cutoff := now.Add(-usageRetention)
+	for id, spans := range r.usage {
+		for len(spans) > 0 && spans[0].Until.Before(cutoff) {
+			spans = spans[1:]
+		}
+		if len(spans) == 0 {
+			delete(r.usage, id)
+		} else {
+			r.usage[id] = spans
+		}
+	}
+}
+
+// Usage returns a copy of the ledger, current as of now, for state.go.
+func (r *Registry) Usage() map[string][]usageSpan {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.clock.Now().UTC()
+	for _, n := range r.nodes {
+		r.accrueUsageLocked(n, now)
+	}
+	out := make(map[string][]usageSpan, len(r.usage))
+	for id, spans := range r.usage {
+		out[id] = slices.Clone(spans)
+	}
+	return out
+}
+
+// RestoreUsage installs a ledger loaded by state.go.
+func (r *Registry) RestoreUsage(usage map[string][]usageSpan) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, spans := range usage {
+		r.usage[id] = spans
+	}
+}
+
+type CostCenterReport struct {
+	CostCenter string  `json:"cost_center"`
+	Nodes      int     `json:"nodes"`
+	NodeHours  float64 `json:"node_hours"`
+	Cost       float64 `json:"cost"`
+}
+
+type CostReport struct {
+	From    time.Time          `json:"from"`
+	To      time.Time          `json:"to"`
+	Centers []CostCenterReport `json:"centers"`
+	Total   float64            `json:"total"`
+}
+
+func (r *Registry) CostReport(from, to time.Time) CostReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.clock.Now().UTC()
+	if to.After(now) {
+		to = now
+	}
+
+	for _, n := range r.nodes {
+		r.accrueUsageLocked(n, now)
+	}
+	byCenter := map[string]*CostCenterReport{}
+	rep := CostReport{From: from, To: to, Centers: []CostCenterReport{}}
+	for _, spans := range r.usage {
+		billed := map[*CostCenterReport]bool{}
+		for _, sp := range spans {
+			hours := sp.within(from, to).Hours()
+			if hours == 0 {
+				continue
+			}
+			center := sp.CostCenter
+			if center == "" {
+				center = unassignedCostCenter
+			}
+			c, ok := byCenter[center]
+			if !ok {
+				c = &CostCenterReport{CostCenter: center}
+				byCenter[center] = c
+			}
+			if !billed[c] {
+				billed[c] = true
+				c.Nodes++
+			}
+			c.NodeHours += hours
+			c.Cost += hours * sp.PricePerHour
+		}
+	}
+	for _, c := range byCenter {
+		rep.Centers = append(rep.Centers, *c)
+		rep.Total += c.Cost
+	}
+	sort.Slice(rep.Centers, func(i, j int) bool { return rep.Centers[i].CostCenter < rep.Centers[j].CostCenter })
+	return rep
+}
+
+// within is the part of sp inside [from, to).
+func (sp usageSpan) within(from, to time.Time) time.Duration {
+	start, end := sp.From, sp.Until
+	if start.Before(from) {
+		start = from
+	}
+	if end.After(to) {
+		end = to
+	}
+	if !end.After(start) {
+		return 0
+	}
+	return end.Sub(start)
+}
+
+// GET /report/cost?from=RFC3339&to=RFC3339 (default: the last 24h)
+func costReportHandler(reg *Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		if !requireKey(w, r) {
+			return
+		}
+		q := r.URL.Query()
+		to := reg.Now().UTC()
+		if v := q.Get("to"); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "bad_request", "to must be RFC3339")
+				return
+			}
+			to = t
+		}
+		from := to.Add(-24 * time.Hour)
+		if v := q.Get("from"); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "bad_request", "from must be RFC3339")
+				return
+			}
+			from = t
+		}
+		if !from.Before(to) {
+			writeError(w, http.StatusBadRequest, "bad_request", "from must be before to")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(reg.CostReport(from, to))
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func costReport(t *testing.T, h http.Handler, from, to time.Time) CostReport {
+	t.Helper()
+	rec := doRequest(t, h, http.MethodGet, "/report/cost?from="+from.Format(time.RFC3339)+"&to="+to.Format(time.RFC3339), nil, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("cost report: status %d: %s", rec.Code, rec.Body)
+	}
+	var rep CostReport
+	if err := json.NewDecoder(rec.Body).Decode(&rep); err != nil {
+		t.Fatalf("decode cost report: %v", err)
+	}
+	return rep
+}
+
+func TestCostReportSurvivesRestart(t *testing.T) {
+	reg, clock, h := newTestServer(t)
+	req := testRegisterRequest("billed", "10.0.0.1")
+	req.CostCenter = "ml"
+	req.PricePerHour = 2
+	id := registerNode(t, h, req).NodeID
+
+	// two hours online, then silence until it goes offline
+	for range 120 {
+		clock.Advance(time.Minute)
+		heartbeat(t, h, id)
+		reg.SweepStatuses(clock.Now())
+	}
+	for range 60 {
+		clock.Advance(time.Minute)
+		reg.SweepStatuses(clock.Now())
+	}
+	if got := getNode(t, h, id).Status; got != "offline" {
+		t.Fatalf("status %q, want offline", got)
+	}
+
+	from, to := testEpoch, clock.Now()
+	want := 2*time.Hour + reg.staleAfter.Round(time.Minute)
+	rep := costReport(t, h, from, to)
+	if len(rep.Centers) != 1 || rep.Centers[0].CostCenter != "ml" {
+		t.Fatalf("centers %+v, want just ml", rep.Centers)
+	}
+	if got := rep.Centers[0].NodeHours; math.Abs(got-want.Hours()) > 1.0/60 {
+		t.Fatalf("node hours %.3f, want about %.3f", got, want.Hours())
+	}
+
+	// the first hour alone, from the ledger rather than the audit log
+	if got := costReport(t, h, from, from.Add(time.Hour)).Centers[0].NodeHours; math.Abs(got-1) > 1e-9 {
+		t.Fatalf("first hour: node hours %.3f, want 1", got)
+	}
+
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := saveState(reg, path); err != nil {
+		t.Fatalf("saveState: %v", err)
+	}
+	restored := NewRegistry(clock)
+	if err := loadState(restored, path); err != nil {
+		t.Fatalf("loadState: %v", err)
+	}
+	h2 := newMux(restored, 1_000_000, 1_000_000)
+	clock.Advance(time.Hour)
+	if got := costReport(t, h2, from, to).Total; math.Abs(got-rep.Total) > 1e-9 {
+		t.Fatalf("total after restart %.4f, want %.4f", got, rep.Total)
+	}
+}
+
+func TestCostReportKeepsDeletedNodes(t *testing.T) {
+	reg, clock, h := newTestServer(t)
+	req := testRegisterRequest("retired", "10.0.0.1")
+	req.CostCenter = "ml"
+	req.PricePerHour = 2
+	id := registerNode(t, h, req).NodeID
+	for range 60 {
+		clock.Advance(time.Minute)
+		heartbeat(t, h, id)
+		reg.SweepStatuses(clock.Now())
+	}
+
+	if rec := doRequest(t, h, http.MethodDelete, "/nodes/"+id, nil, nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("delete: status %d: %s", rec.Code, rec.Body)
+	}
+	clock.Advance(time.Hour)
+	rep := costReport(t, h, testEpoch, clock.Now())
+	if len(rep.Centers) != 1 || math.Abs(rep.Centers[0].NodeHours-1) > 1e-9 || math.Abs(rep.Total-2) > 1e-9 {
+		t.Fatalf("after delete got %+v, want 1 node-hour costing 2", rep)
+	}
+}
+
+func TestRepricingKeepsPastHours(t *testing.T) {
+	reg, clock, h := newTestServer(t)
+	req := testRegisterRequest("repriced", "10.0.0.1")
+	req.CostCenter = "ml"
+	req.PricePerHour = 2
+	id := registerNode(t, h, req).NodeID
+	run := func() {
+		for range 60 {
+			clock.Advance(time.Minute)
+			heartbeat(t, h, id)
+			reg.SweepStatuses(clock.Now())
+		}
+	}
+	run()
+
+	req.CostCenter = "web"
+	req.PricePerHour = 5
+	if got := registerNode(t, h, req).NodeID; got != id {
+		t.Fatalf("re-register made a new record %s", got)
+	}
+	run()
+
+	rep := costReport(t, h, testEpoch, clock.Now())
+	got := map[string]CostCenterReport{}
+	for _, c := range rep.Centers {
+		got[c.CostCenter] = c
+	}
+	if ml := got["ml"]; math.Abs(ml.NodeHours-1) > 1e-9 || math.Abs(ml.Cost-2) > 1e-9 {
+		t.Fatalf("ml %+v, want 1h costing 2", ml)
+	}
+	if web := got["web"]; math.Abs(web.NodeHours-1) > 1e-9 || math.Abs(web.Cost-5) > 1e-9 {
+		t.Fatalf("web %+v, want 1h costing 5", web)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// ---------- Draining ----------
+// Status state machine:
+//
+//	online <-> draining   (POST /nodes/{id}/drain toggles the Draining flag)
+//	online|draining -> stale -> offline   (stale monitor, missed heartbeats)
+//	stale|offline -> online|draining      (next heartbeat or re-register)
+//
+// Draining is sticky: while the flag is set, a heartbeat or re-register
+// lands the node back in "draining" rather than "online", so a node that
+// blipped stale mid-drain still gets no new work. Unlike maintenance,
+// draining is visible in Status itself.
+
+// liveStatus is the status a node that just checked in should have.
+func liveStatus(n *NodeRecord) string {
+	if n.Draining {
+		return "draining"
+	}
+	return "online"
+}
+
+func (r *Registry) SetDraining(id string, enabled bool) (NodeRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n, ok := r.nodes[id]
+	if !ok {
+		return NodeRecord{}, errUnknownNode
+	}
+	if n.Draining == enabled {
+		return r.snapshotLocked(n), nil
+	}
+	n.Draining = enabled
+	r.touchLocked(n)
+	r.auditLocked("drain", id, "enabled="+strconv.FormatBool(enabled))
+	if n.Status == "online" || n.Status == "draining" {
+		r.setStatusLocked(n, liveStatus(n))
+	}
+	r.hub.publish("status", r.snapshotLocked(n))
+	return r.snapshotLocked(n), nil
+}
+
+// POST /nodes/{id}/drain, optional body {"enabled": false} to undrain
+func drainHandler(reg *Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+		if !requireKey(w, r) {
+			return
+		}
+		id := r.PathValue("id")
+		logNodeID(r, id)
+
+		body := struct {
+			Enabled bool `json:"enabled"`
+		}{Enabled: true}
+		if r.ContentLength != 0 && !decodeJSON(w, r, heartbeatBodyLimit, &body) {
+			return
+		}
+
+		node, err := reg.SetDraining(id, body.Enabled)
+		if err != nil {
+			writeErrorFor(w, http.StatusNotFound, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"node_id":  node.NodeID,
+			"draining": node.Draining,
+			"status":   node.Status,
+		})
+	}
+}
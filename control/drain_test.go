@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestReserveRefusedWhileDraining(t *testing.T) {
+	_, _, h := newTestServer(t)
+	id := registerNode(t, h, testRegisterRequest("drainer", "10.0.0.1")).NodeID
+	lease := leaseHeader(acquireLease(t, h, id, "sched"))
+	if rec := doRequest(t, h, http.MethodPost, "/nodes/"+id+"/reserve", nil, lease); rec.Code != http.StatusOK {
+		t.Fatalf("reserve: status %d: %s", rec.Code, rec.Body)
+	}
+
+	if rec := doRequest(t, h, http.MethodPost, "/nodes/"+id+"/drain", nil, nil); rec.Code != http.StatusOK {
+		t.Fatalf("drain: status %d: %s", rec.Code, rec.Body)
+	}
+	rec := doRequest(t, h, http.MethodPost, "/nodes/"+id+"/reserve", nil, lease)
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("reserve while draining: status %d, want 409", rec.Code)
+	}
+	if code := decodeErrorCode(t, rec); code != "not_schedulable" {
+		t.Fatalf("reserve while draining: code %q, want not_schedulable", code)
+	}
+	// jobs already running still hand their slots back
+	if rec := doRequest(t, h, http.MethodPost, "/nodes/"+id+"/release", nil, nil); rec.Code != http.StatusOK {
+		t.Fatalf("release while draining: status %d: %s", rec.Code, rec.Body)
+	}
+}
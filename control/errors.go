@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// ---------- Error responses ----------
+// Every error body is {"error": {"code": "...", "message": "..."}}.
+// code is stable and meant for programs; message is for humans and may
+// change. Validation failures also list each offending field in details.
+type APIError struct {
+	Code    string   `json:"code"`
+	Message string   `json:"message"`
+	Details []string `json:"details,omitempty"`
+}
+
+type errorEnvelope struct {
+	Error APIError `json:"error"`
+}
+
+// errorCodes maps the shared sentinel errors to their wire codes.
+var errorCodes = []struct {
+	err  error
+	code string
+}{
+	{errUnknownNode, "unknown_node"},
+	{errNoCapacity, "no_capacity"},
+	{errNoReservation, "no_reservation"},
+	{errUnschedulable, "not_schedulable"},
+	{errRegistryFull, "registry_full"},
+	{errHostnameTaken, "hostname_taken"},
+	{errQuarantined, "quarantined"},
+	{errNoMatch, "no_match"},
+	{errPowerBudget, "power_budget_exceeded"},
+	{errLeased, "leased"},
+	{errNotLease, "not_lease_holder"},
+	{errLeaseRequired, "lease_required"},
+	{errIdemKeyReused, "idempotency_key_reused"},
+	{errQueueFull, "queue_full"},
+	{errUnknownCommand, "unknown_command"},
+	{errBadToken, "unauthorized"},
+}
+
+// apiError describes err for the wire; anything unrecognized is a bad request.
+func apiError(err error) APIError {
+	var ve *ValidationError
+	if errors.As(err, &ve) {
+		return APIError{Code: "validation_failed", Message: err.Error(), Details: ve.Fields}
+	}
+	for _, c := range errorCodes {
+		if errors.Is(err, c.err) {
+			return APIError{Code: c.code, Message: err.Error()}
+		}
+	}
+	return APIError{Code: "bad_request", Message: err.Error()}
+}
+
+func writeError(w http.ResponseWriter, status int, code, msg string) {
+	writeAPIError(w, status, APIError{Code: code, Message: msg})
+}
+
+// writeErrorFor is writeError with the code derived from err.
+func writeErrorFor(w http.ResponseWriter, status int, err error) {
+	writeAPIError(w, status, apiError(err))
+}
+
+func writeAPIError(w http.ResponseWriter, status int, e APIError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorEnvelope{Error: e})
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ---------- ETag ----------
+// listETag hashes the node list a /nodes request would return. The
+// per-read last_seen_age_sec is left out, otherwise every second would
+// look like a change; last_seen itself still moves on each heartbeat.
+// The request URI is mixed in because it decides filtering, paging and format.
+func listETag(nodes []NodeRecord, uri string) string {
+	h := sha256.New()
+	h.Write([]byte(uri))
+	enc := json.NewEncoder(h)
+	for _, n := range nodes {
+		n.LastSeenAgeSec = 0
+		enc.Encode(n)
+	}
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// notModified sets the ETag and reports whether If-None-Match already has it.
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+	inm := r.Header.Get("If-None-Match")
+	if inm == "" {
+		return false
+	}
+	for _, t := range strings.Split(inm, ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == etag || t == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// ---------- Last-Modified ----------
+// notModifiedSince sets Last-Modified and reports whether If-Modified-Since
+// is still current. HTTP dates have one-second resolution, so a change in
+// the same second as the client's copy goes unnoticed until the next one.
+func notModifiedSince(w http.ResponseWriter, r *http.Request, modified time.Time) bool {
+	if modified.IsZero() {
+		return false
+	}
+	modified = modified.Truncate(time.Second)
+	w.Header().Set("Last-Modified", modified.Format(http.TimeFormat))
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	return err == nil && !modified.After(ims)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"sync"
+)
+
+// ---------- Node events ----------
+// Registry mutations publish a NodeEvent to every subscriber. Publishing
+// happens under the registry lock, so a subscriber that grabs its
+// snapshot under the same lock never misses or double-sees a change.
+const subscriberBuffer = 64
+
+type NodeEvent struct {
+	Type  string       `json:"type"` // snapshot / registered / heartbeat / status / labels / maintenance / deleted / deregistered / evicted / purged
+	Node  *NodeRecord  `json:"node,omitempty"`
+	Nodes []NodeRecord `json:"nodes,omitempty"` // snapshot only
+}
+
+type eventHub struct {
+	mu   sync.Mutex
+	subs map[chan NodeEvent]struct{}
+}
+
+// publish never blocks; a subscriber that can't keep up is dropped and
+// its channel closed so the client reconnects and resyncs from a snapshot.
+func (h *eventHub) publish(typ string, n NodeRecord) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ev := NodeEvent{Type: typ, Node: &n}
+	for ch := range h.subs {
+		select {
+		case ch <- ev:
+		default:
+			delete(h.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+func (h *eventHub) subscribe() chan NodeEvent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.subs == nil {
+		h.subs = map[chan NodeEvent]struct{}{}
+	}
+	ch := make(chan NodeEvent, subscriberBuffer)
+	h.subs[ch] = struct{}{}
+	return ch
+}
+
+func (h *eventHub) unsubscribe(ch chan NodeEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.subs[ch]; ok {
+		delete(h.subs, ch)
+		close(ch)
+	}
+}
+
+// closeAll disconnects every subscriber (used on shutdown).
+func (h *eventHub) closeAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.subs {
+		delete(h.subs, ch)
+		close(ch)
+	}
+}
+
+// Subscribe returns the current nodes plus a channel of subsequent changes.
+// Call the returned cancel func when done.
+func (r *Registry) Subscribe() ([]NodeRecord, <-chan NodeEvent, func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	snapshot := r.listLocked(nodeFilter{})
+	ch := r.hub.subscribe()
+	return snapshot, ch, func() { r.hub.unsubscribe(ch) }
+}
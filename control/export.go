@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/csv"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ---------- CSV export ----------
+// GET /nodes.csv or /nodes?format=csv, same filters as the JSON list.
+var csvHeader = []string{
+	"node_id", "hostname", "ip", "os", "arch", "agent_version",
+	"ram_gb", "cores", "gpu_count", "power_w", "status", "last_seen",
+}
+
+func wantsCSV(r *http.Request) bool {
+	return r.URL.Path == "/nodes.csv" || r.URL.Query().Get("format") == "csv"
+}
+
+func writeNodesCSV(w http.ResponseWriter, nodes []NodeRecord) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="nodes.csv"`)
+
+	cw := csv.NewWriter(w)
+	cw.Write(csvHeader)
+	for _, n := range nodes {
+		cw.Write([]string{
+			csvCell(n.NodeID),
+			csvCell(n.Hostname),
+			csvCell(n.ReportedIP),
+			csvCell(n.OS),
+			csvCell(n.Arch),
+			csvCell(n.AgentVersion),
+			strconv.Itoa(n.RAMGB),
+			strconv.Itoa(n.CPU.Cores),
+			strconv.Itoa(len(n.GPU)),
+			strconv.Itoa(n.PowerW),
+			csvCell(n.Status),
+			n.LastSeen.Format(time.RFC3339),
+		})
+	}
+	cw.Flush()
+}
+
+// csvCell defuses agent-supplied text a spreadsheet would run as a
+// formula, by prefixing a quote.
+func csvCell(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
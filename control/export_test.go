@@ -0,0 +1,33 @@
+package main
+
+import (
+	"encoding/csv"
+	"net/http"
+	"testing"
+)
+
+func TestCSVExportEscapesFormulas(t *testing.T) {
+	_, _, h := newTestServer(t)
+	req := testRegisterRequest("=HYPERLINK(\"http://evil\",\"x\")", "10.0.0.1")
+	req.AgentVersion = "@SUM(A1)"
+	registerNode(t, h, req)
+
+	rec := doRequest(t, h, http.MethodGet, "/nodes.csv", nil, nil)
+	rows, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("parse csv: %v", err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("got %d rows, want header + 1", len(rows))
+	}
+	row := rows[1]
+	if got, want := row[1], "'"+req.Hostname; got != want {
+		t.Errorf("hostname cell %q, want %q", got, want)
+	}
+	if got, want := row[5], "'@SUM(A1)"; got != want {
+		t.Errorf("agent_version cell %q, want %q", got, want)
+	}
+	if got := row[2]; got != "10.0.0.1" {
+		t.Errorf("ip cell %q changed", got)
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ---------- Node filters ----------
+// Query params understood by /nodes. Unknown params are ignored and
+// every criterion is AND-ed together.
+type nodeFilter struct {
+	Status  string
+	Region  string
+	Group   string
+	Runtime string
+	Labels  []string
+	Meta    map[string]string // from ?meta.<key>=<value>
+	GPU     GPUQuery
+
+	MinRAMGB  int
+	MinCores  int
+	MinPowerW int
+	MaxPowerW *int // nil when absent; 0 is a real bound (idle nodes)
+
+	HideStale   bool // ?hide_stale=true
+	HideOffline bool // ?hide_offline=true
+
+	// MinFreeSlots (?min_free_slots) also restricts to schedulable nodes
+	// and switches the list to most-free-first; nil when absent.
+	MinFreeSlots *int
+
+	LabelPrefixes []string // key scope, set by the handler rather than the query
+}
+
+func parseNodeFilter(q url.Values) (nodeFilter, error) {
+	f := nodeFilter{
+		Status:  q.Get("status"),
+		Region:  q.Get("region"),
+		Group:   q.Get("group"),
+		Runtime: normalizeRuntime(q.Get("runtime")),
+		Labels:  normalizeLabels(q["label"]),
+	}
+	for k, v := range q {
+		if key, ok := strings.CutPrefix(k, "meta."); ok && key != "" {
+			if f.Meta == nil {
+				f.Meta = map[string]string{}
+			}
+			f.Meta[key] = v[0]
+		}
+	}
+	f.GPU.Name = q.Get("gpu_name")
+	var err error
+	if f.GPU.MinVRAMGB, err = queryInt(q, "gpu_min_vram_gb"); err != nil {
+		return f, err
+	}
+	if f.MinRAMGB, err = queryInt(q, "min_ram_gb"); err != nil {
+		return f, err
+	}
+	if f.MinCores, err = queryInt(q, "min_cores"); err != nil {
+		return f, err
+	}
+	if f.MinPowerW, err = queryInt(q, "min_power_w"); err != nil {
+		return f, err
+	}
+	if q.Has("max_power_w") {
+		n, err := queryInt(q, "max_power_w")
+		if err != nil {
+			return f, err
+		}
+		f.MaxPowerW = &n
+	}
+	if f.HideStale, err = queryBool(q, "hide_stale"); err != nil {
+		return f, err
+	}
+	if f.HideOffline, err = queryBool(q, "hide_offline"); err != nil {
+		return f, err
+	}
+	if q.Has("min_free_slots") {
+		n, err := queryInt(q, "min_free_slots")
+		if err != nil {
+			return f, err
+		}
+		f.MinFreeSlots = &n
+	}
+	return f, nil
+}
+
+// queryInt parses a non-negative integer param; absent means 0.
+func queryInt(q url.Values, name string) (int, error) {
+	v := q.Get(name)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s must be a non-negative integer", name)
+	}
+	return n, nil
+}
+
+// queryBool parses a boolean param; absent means false.
+func queryBool(q url.Values, name string) (bool, error) {
+	v := q.Get(name)
+	if v == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("%s must be true or false", name)
+	}
+	return b, nil
+}
+
+func (f nodeFilter) match(n *NodeRecord) bool {
+	if f.Status != "" && n.Status != f.Status {
+		return false
+	}
+	if (f.HideStale && n.Status == "stale") || (f.HideOffline && n.Status == "offline") {
+		return false
+	}
+	if f.Region != "" && n.Region != f.Region {
+		return false
+	}
+	if f.Group != "" && n.Group != f.Group {
+		return false
+	}
+	if f.Runtime != "" && n.Runtime != f.Runtime {
+		return false
+	}
+	for _, l := range f.Labels {
+		if !slices.Contains(n.Labels, l) {
+			return false
+		}
+	}
+	if f.LabelPrefixes != nil && !hasLabelPrefix(n.Labels, f.LabelPrefixes) {
+		return false
+	}
+	for k, v := range f.Meta {
+		if got, ok := n.Meta[k]; !ok || got != v {
+			return false
+		}
+	}
+	if !f.GPU.matches(n.GPU) {
+		return false
+	}
+	if n.RAMGB < f.MinRAMGB || n.CPU.Cores < f.MinCores {
+		return false
+	}
+	if n.PowerW < f.MinPowerW || (f.MaxPowerW != nil && n.PowerW > *f.MaxPowerW) {
+		return false
+	}
+	if f.MinFreeSlots != nil && (!schedulable(n) || freeSlots(n) < *f.MinFreeSlots) {
+		return false
+	}
+	return true
+}
+
+// sort orders a List result for f. Lists are by NodeID unless the caller
+// asked for free capacity, where greedy schedulers want the emptiest first.
+func (f nodeFilter) sort(nodes []NodeRecord) {
+	if f.MinFreeSlots == nil {
+		return
+	}
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return freeSlots(&nodes[i]) > freeSlots(&nodes[j])
+	})
+}
+
+// GPUQuery matches nodes with at least one GPU whose name contains Name
+// (case-insensitive) and that has at least MinVRAMGB. The zero value
+// matches every node, with or without GPUs.
+type GPUQuery struct {
+	Name      string
+	MinVRAMGB int
+}
+
+func (q GPUQuery) matches(gpus []GPUInfo) bool {
+	if q.Name == "" && q.MinVRAMGB == 0 {
+		return true
+	}
+	name := strings.ToLower(q.Name)
+	return slices.ContainsFunc(gpus, func(g GPUInfo) bool {
+		return g.VRAMGB >= q.MinVRAMGB && strings.Contains(strings.ToLower(g.Name), name)
+	})
+}
+
+// ---------- Pagination ----------
+// Only used when ?limit or ?offset is present; otherwise /nodes keeps
+// returning a bare array.
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+)
+
+type NodePage struct {
+	Nodes  []NodeRecord `json:"nodes"`
+	Total  int          `json:"total"`
+	Limit  int          `json:"limit"`
+	Offset int          `json:"offset"`
+}
+
+type pageParams struct {
+	limit, offset int
+}
+
+// parsePage reports ok=false when no pagination params were given.
+func parsePage(q url.Values) (p pageParams, ok bool, err error) {
+	if !q.Has("limit") && !q.Has("offset") {
+		return p, false, nil
+	}
+	p.limit = defaultPageLimit
+	if v := q.Get("limit"); v != "" {
+		p.limit, err = strconv.Atoi(v)
+		if err != nil || p.limit <= 0 || p.limit > maxPageLimit {
+			return p, true, fmt.Errorf("limit must be 1..%d", maxPageLimit)
+		}
+	}
+	if v := q.Get("offset"); v != "" {
+		p.offset, err = strconv.Atoi(v)
+		if err != nil || p.offset < 0 {
+			return p, true, fmt.Errorf("offset must be >= 0")
+		}
+	}
+	return p, true, nil
+}
+
+// page slices nodes, which must already be in a stable order.
+func (p pageParams) page(nodes []NodeRecord) NodePage {
+	start := min(p.offset, len(nodes))
+	end := min(start+p.limit, len(nodes))
+	return NodePage{
+		Nodes:  nodes[start:end],
+		Total:  len(nodes),
+		Limit:  p.limit,
+		Offset: p.offset,
+	}
+}
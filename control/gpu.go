@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// ---------- GPU utilization ----------
+// Heartbeats may carry live per-GPU usage. Each entry is matched to a
+// registered GPU by index when the payload lists the same number of
+// GPUs, otherwise by name (first unclaimed match). Anything that matches
+// nothing is dropped: the GPU set only changes on re-register.
+type GPUUsage struct {
+	Index     *int   `json:"index,omitempty"`
+	Name      string `json:"name,omitempty"`
+	UtilPct   int    `json:"util_pct"`
+	MemUsedMB int    `json:"mem_used_mb"`
+}
+
+// applyGPUUsage returns gpus with usage folded in, reporting how many
+// entries were dropped. gpus itself is never modified (snapshots share it).
+func applyGPUUsage(gpus []GPUInfo, usage []GPUUsage) ([]GPUInfo, int) {
+	out := make([]GPUInfo, len(gpus))
+	copy(out, gpus)
+	claimed := make([]bool, len(out))
+	byIndex := len(usage) == len(out)
+
+	dropped := 0
+	for _, u := range usage {
+		i := -1
+		if byIndex && u.Index != nil && *u.Index >= 0 && *u.Index < len(out) && !claimed[*u.Index] {
+			i = *u.Index
+		} else if u.Name != "" {
+			for j, g := range out {
+				if !claimed[j] && strings.EqualFold(g.Name, u.Name) {
+					i = j
+					break
+				}
+			}
+		}
+		if i < 0 {
+			dropped++
+			continue
+		}
+		claimed[i] = true
+		util := min(max(u.UtilPct, 0), 100)
+		mem := max(u.MemUsedMB, 0)
+		out[i].UtilPct = &util
+		out[i].MemUsedMB = &mem
+	}
+	return out, dropped
+}
+
+// ---------- GPU inventory ----------
+// GET /gpus counts GPUs by model. Online means the node's status is
+// online; a GPU is in use once its last reported utilization reaches
+// gpuBusyUtilPct, and available when it is online on a schedulable node
+// and not in use. GPUs whose agent never reported usage count as
+// available and are also tallied under Untracked.
+const gpuBusyUtilPct = 10
+
+type GPUCount struct {
+	Total     int `json:"total"`
+	Online    int `json:"online"`
+	InUse     int `json:"in_use"`
+	Available int `json:"available"`
+	Untracked int `json:"untracked"` // online, no utilization reported
+}
+
+type GPUModel struct {
+	Name   string `json:"name"`
+	VRAMGB int    `json:"vram_gb"`
+	GPUCount
+}
+
+type GPUInventory struct {
+	Models []GPUModel `json:"models"`
+	Totals GPUCount   `json:"totals"`
+}
+
+func (c *GPUCount) add(n *NodeRecord, g GPUInfo) {
+	c.Total++
+	if n.Status != "online" {
+		return
+	}
+	c.Online++
+	switch {
+	case g.UtilPct == nil:
+		c.Untracked++
+	case *g.UtilPct >= gpuBusyUtilPct:
+		c.InUse++
+		return
+	}
+	if schedulable(n) {
+		c.Available++
+	}
+}
+
+func (r *Registry) GPUInventory() GPUInventory {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	type key struct {
+		name string
+		vram int
+	}
+	byModel := map[key]*GPUModel{}
+	var inv GPUInventory
+	for _, n := range r.nodes {
+		for _, g := range n.GPU {
+			k := key{strings.TrimSpace(g.Name), g.VRAMGB}
+			m, ok := byModel[k]
+			if !ok {
+				m = &GPUModel{Name: k.name, VRAMGB: k.vram}
+				byModel[k] = m
+			}
+			m.add(n, g)
+			inv.Totals.add(n, g)
+		}
+	}
+
+	inv.Models = make([]GPUModel, 0, len(byModel))
+	for _, m := range byModel {
+		inv.Models = append(inv.Models, *m)
+	}
+	sort.Slice(inv.Models, func(i, j int) bool {
+		a, b := inv.Models[i], inv.Models[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.VRAMGB > b.VRAMGB
+	})
+	return inv
+}
+
+// GET /gpus
+func gpuInventoryHandler(reg *Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		if !requireKey(w, r) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(reg.GPUInventory())
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// ---------- Power history ----------
+// Fixed-size ring of power samples per node, filled on each heartbeat.
+const defaultPowerHistorySize = 120
+
+type PowerSample struct {
+	Timestamp time.Time `json:"timestamp"`
+	PowerW    int       `json:"power_w"`
+}
+
+type powerRing struct {
+	buf  []PowerSample
+	next int
+	full bool
+}
+
+func newPowerRing(size int) *powerRing {
+	return &powerRing{buf: make([]PowerSample, size)}
+}
+
+func (p *powerRing) push(s PowerSample) {
+	p.buf[p.next] = s
+	p.next = (p.next + 1) % len(p.buf)
+	if p.next == 0 {
+		p.full = true
+	}
+}
+
+// samples returns oldest-first.
+func (p *powerRing) samples() []PowerSample {
+	if !p.full {
+		return append([]PowerSample(nil), p.buf[:p.next]...)
+	}
+	out := make([]PowerSample, 0, len(p.buf))
+	out = append(out, p.buf[p.next:]...)
+	return append(out, p.buf[:p.next]...)
+}
+
+func (r *Registry) PowerHistory(id string) ([]PowerSample, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n, ok := r.nodes[id]
+	if !ok {
+		return nil, false
+	}
+	if n.power == nil {
+		return []PowerSample{}, true
+	}
+	return n.power.samples(), true
+}
+
+func powerHistoryHandler(reg *Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		if !requireKey(w, r) {
+			return
+		}
+		id := r.PathValue("id")
+		logNodeID(r, id)
+
+		samples, ok := reg.PowerHistory(id)
+		if !ok {
+			writeError(w, http.StatusNotFound, "unknown_node", "unknown node_id")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"node_id": id,
+			"samples": samples,
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// ---------- Idempotency keys ----------
+// Schedulers retry reserve/release on network errors. With an
+// Idempotency-Key header the first outcome (success or failure) is kept
+// per node for idemTTL, and a retry gets that outcome back instead of
+// moving JobsRunning again.
+const (
+	idemHeader = "Idempotency-Key"
+	idemTTL    = 5 * time.Minute
+	maxIdemKey = 128
+)
+
+var errIdemKeyReused = errors.New("idempotency key already used for the other operation")
+
+type idemResult struct {
+	reserve bool
+	node    NodeRecord
+	err     error
+	at      time.Time
+}
+
+func idemCacheKey(nodeID, key string) string { return nodeID + "\x00" + key }
+
+func (r *Registry) idemLookupLocked(nodeID, key string) (idemResult, bool) {
+	res, ok := r.idem[idemCacheKey(nodeID, key)]
+	if !ok || r.clock.Now().Sub(res.at) > idemTTL {
+		return idemResult{}, false
+	}
+	return res, true
+}
+
+func (r *Registry) idemStoreLocked(nodeID, key string, res idemResult) {
+	res.at = r.clock.Now()
+	r.idem[idemCacheKey(nodeID, key)] = res
+}
+
+// idemExpireLocked runs from the sweep so the cache can't grow unbounded.
+func (r *Registry) idemExpireLocked(now time.Time) {
+	for k, res := range r.idem {
+		if now.Sub(res.at) > idemTTL {
+			delete(r.idem, k)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+// ---------- Label mutation ----------
+// Agents own AgentLabels and replace them on every registration; the
+// PATCH endpoint only edits ServerLabels, so operator tags stick. Labels
+// is the union of the two and is what filters, scopes and /schedule use.
+// maxLabels caps that union, whichever side would push it over.
+type LabelPatch struct {
+	Add    []string `json:"add,omitempty"`
+	Remove []string `json:"remove,omitempty"`
+}
+
+// apply returns a new slice (records handed out earlier share the old one):
+// existing order kept, removals dropped, additions appended, no duplicates.
+func (p LabelPatch) apply(labels []string) []string {
+	out := make([]string, 0, len(labels)+len(p.Add))
+	for _, l := range append(slices.Clone(labels), p.Add...) {
+		if slices.Contains(p.Remove, l) || slices.Contains(out, l) {
+			continue
+		}
+		out = append(out, l)
+	}
+	return out
+}
+
+// checkLabelCap refuses agent+server label sets whose union is over maxLabels.
+func checkLabelCap(field string, agent, server []string) error {
+	if n := len(LabelPatch{Add: server}.apply(agent)); n > maxLabels {
+		return &ValidationError{Fields: []string{fmt.Sprintf("%s: a node can have at most %d labels, agent and server combined (would have %d)", field, maxLabels, n)}}
+	}
+	return nil
+}
+
+// mergeLabels recomputes Labels, always into a fresh slice.
+func (n *NodeRecord) mergeLabels() {
+	n.Labels = LabelPatch{Add: n.ServerLabels}.apply(n.AgentLabels)
+}
+
+// PatchLabels edits a node's server labels without touching LastSeen or
+// Status. Removing a label the agent reports has no effect on Labels.
+func (r *Registry) PatchLabels(id string, p LabelPatch) (NodeRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n, ok := r.nodes[id]
+	if !ok {
+		return NodeRecord{}, errUnknownNode
+	}
+	labels := p.apply(n.ServerLabels)
+	if err := checkLabelCap("add", n.AgentLabels, labels); err != nil {
+		return NodeRecord{}, err
+	}
+	n.ServerLabels = labels
+	n.mergeLabels()
+	r.touchLocked(n)
+	r.hub.publish("labels", r.snapshotLocked(n))
+	return r.snapshotLocked(n), nil
+}
+
+// PATCH /nodes/{id}/labels
+func patchLabelsHandler(reg *Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPatch {
+			methodNotAllowed(w, http.MethodPatch)
+			return
+		}
+		if !requireKey(w, r) {
+			return
+		}
+		id := r.PathValue("id")
+		logNodeID(r, id)
+
+		var p LabelPatch
+		if !decodeJSON(w, r, registerBodyLimit, &p) {
+			return
+		}
+		p.Add, p.Remove = normalizeLabels(p.Add), normalizeLabels(p.Remove)
+		var ve ValidationError
+		validateLabels("add", p.Add, &ve)
+		if err := ve.orNil(); err != nil {
+			writeErrorFor(w, http.StatusBadRequest, err)
+			return
+		}
+		node, err := reg.PatchLabels(id, p)
+		switch {
+		case errors.Is(err, errUnknownNode):
+			writeErrorFor(w, http.StatusNotFound, err)
+			return
+		case err != nil:
+			writeErrorFor(w, http.StatusBadRequest, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"node_id":       id,
+			"labels":        node.Labels,
+			"agent_labels":  node.AgentLabels,
+			"server_labels": node.ServerLabels,
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func numberedLabels(prefix string, n int) []string {
+	out := make([]string, n)
+	for i := range out {
+		out[i] = fmt.Sprintf("%s%d", prefix, i)
+	}
+	return out
+}
+
+func TestLabelCapCoversAgentAndServerLabels(t *testing.T) {
+	_, _, h := newTestServer(t)
+	req := testRegisterRequest("tagged", "10.0.0.1")
+	req.Labels = numberedLabels("agent-", maxLabels-2)
+	id := registerNode(t, h, req).NodeID
+	patch := func(add ...string) int {
+		return doRequest(t, h, http.MethodPatch, "/nodes/"+id+"/labels", LabelPatch{Add: add}, nil).Code
+	}
+
+	if code := patch("ops-0", "ops-1"); code != http.StatusOK {
+		t.Fatalf("patch up to the cap: status %d", code)
+	}
+	rec := doRequest(t, h, http.MethodPatch, "/nodes/"+id+"/labels", LabelPatch{Add: []string{"ops-2"}}, nil)
+	if rec.Code != http.StatusBadRequest || decodeErrorCode(t, rec) != "validation_failed" {
+		t.Fatalf("patch past the cap: status %d: %s", rec.Code, rec.Body)
+	}
+	// a label the agent already reports adds nothing to the union
+	if code := patch("agent-0"); code != http.StatusOK {
+		t.Fatalf("patch with a duplicate label: status %d", code)
+	}
+
+	// the agent can't push the union over either
+	req.Labels = append(req.Labels, "agent-new")
+	if rec := doRequest(t, h, http.MethodPost, "/register", req, nil); rec.Code != http.StatusBadRequest {
+		t.Fatalf("re-register past the cap: status %d: %s", rec.Code, rec.Body)
+	}
+	if n := getNode(t, h, id); len(n.Labels) != maxLabels {
+		t.Fatalf("node has %d labels, want %d", len(n.Labels), maxLabels)
+	}
+}
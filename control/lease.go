@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// ---------- Leases ----------
+// A scheduler takes an exclusive, expiring lease on a node before
+// reserving on it: /nodes/{id}/reserve needs the active lease's token in
+// X-LEGION-LEASE-TOKEN, and /schedule skips nodes leased to anyone but
+// the token sent there. LEGION_UNLEASED_RESERVE=true lets nodes nobody
+// holds be reserved without a lease, for single-scheduler setups.
+// Releasing a slot never needs the lease, since jobs end on their own
+// schedule. Leases are renewed by re-posting with the current token, and
+// are not persisted: a restart frees every node.
+const (
+	leaseTokenHeader = "X-LEGION-LEASE-TOKEN"
+	defaultLeaseTTL  = 30 * time.Second
+	maxLeaseTTL      = 10 * time.Minute
+)
+
+var (
+	errLeased        = errors.New("node leased by another holder")
+	errNotLease      = errors.New("no active lease with that token")
+	errLeaseRequired = errors.New("reserving needs an active lease on the node")
+)
+
+type Lease struct {
+	Holder    string    `json:"holder,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+
+	tokenHash string
+}
+
+// activeLeaseLocked returns n's lease, or nil if it has none or it expired.
+func (r *Registry) activeLeaseLocked(n *NodeRecord) *Lease {
+	if n.Lease == nil || !r.clock.Now().Before(n.Lease.ExpiresAt) {
+		return nil
+	}
+	return n.Lease
+}
+
+// checkLeaseLocked lets token reserve on n: fine when token is the active
+// lease's, or when n is unleased and LEGION_UNLEASED_RESERVE allows that.
+func (r *Registry) checkLeaseLocked(n *NodeRecord, token string) error {
+	l := r.activeLeaseLocked(n)
+	switch {
+	case l == nil && r.unleasedReserve:
+		return nil
+	case l == nil:
+		return errLeaseRequired
+	case !leaseHeldBy(l, token):
+		return errLeased
+	}
+	return nil
+}
+
+func leaseHeldBy(l *Lease, token string) bool {
+	return token != "" && hashToken(token) == l.tokenHash
+}
+
+// AcquireLease grants or renews a lease. token is empty for a new lease;
+// the returned token is the one to present from then on.
+func (r *Registry) AcquireLease(id, holder, token string, ttl time.Duration) (Lease, string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n, ok := r.nodes[id]
+	if !ok {
+		return Lease{}, "", errUnknownNode
+	}
+	cur := r.activeLeaseLocked(n)
+	switch {
+	case cur == nil:
+		t, err := randomID(16)
+		if err != nil {
+			return Lease{}, "", err
+		}
+		token = t
+	case !leaseHeldBy(cur, token):
+		return *cur, "", errLeased
+	}
+	// replaced, never edited in place: snapshots share the pointer
+	n.Lease = &Lease{
+		Holder:    holder,
+		ExpiresAt: r.clock.Now().UTC().Add(ttl),
+		tokenHash: hashToken(token),
+	}
+	r.touchLocked(n)
+	if cur == nil {
+		r.auditLocked("lease", id, "acquired by "+holder)
+	}
+	return *n.Lease, token, nil
+}
+
+// ReleaseLease drops the lease if token holds it.
+func (r *Registry) ReleaseLease(id, token string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n, ok := r.nodes[id]
+	if !ok {
+		return errUnknownNode
+	}
+	l := r.activeLeaseLocked(n)
+	if l == nil || !leaseHeldBy(l, token) {
+		return errNotLease
+	}
+	n.Lease = nil
+	r.touchLocked(n)
+	r.auditLocked("lease", id, "released by "+l.Holder)
+	return nil
+}
+
+// POST /nodes/{id}/lease {"holder", "ttl_sec"} (+ X-LEGION-LEASE-TOKEN to renew)
+// DELETE /nodes/{id}/lease with X-LEGION-LEASE-TOKEN
+func leaseHandler(reg *Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+			methodNotAllowed(w, http.MethodPost, http.MethodDelete)
+			return
+		}
+		if !requireKey(w, r) {
+			return
+		}
+		id := r.PathValue("id")
+		logNodeID(r, id)
+		token := r.Header.Get(leaseTokenHeader)
+
+		if r.Method == http.MethodDelete {
+			switch err := reg.ReleaseLease(id, token); {
+			case errors.Is(err, errUnknownNode):
+				writeErrorFor(w, http.StatusNotFound, err)
+			case err != nil:
+				writeErrorFor(w, http.StatusConflict, err)
+			default:
+				w.WriteHeader(http.StatusNoContent)
+			}
+			return
+		}
+
+		var body struct {
+			Holder string `json:"holder"`
+			TTLSec int    `json:"ttl_sec"`
+		}
+		if !decodeJSON(w, r, heartbeatBodyLimit, &body) {
+			return
+		}
+		ttl := defaultLeaseTTL
+		if body.TTLSec != 0 {
+			ttl = time.Duration(body.TTLSec) * time.Second
+		}
+		if ttl <= 0 || ttl > maxLeaseTTL {
+			writeError(w, http.StatusBadRequest, "bad_request", "ttl_sec must be 1..600")
+			return
+		}
+
+		lease, token, err := reg.AcquireLease(id, body.Holder, token, ttl)
+		switch {
+		case errors.Is(err, errUnknownNode):
+			writeErrorFor(w, http.StatusNotFound, err)
+			return
+		case errors.Is(err, errLeased):
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]any{"error": apiError(err), "lease": lease})
+			return
+		case err != nil:
+			writeError(w, http.StatusInternalServerError, "internal", "internal error")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"node_id":     id,
+			"lease_token": token,
+			"holder":      lease.Holder,
+			"expires_at":  lease.ExpiresAt,
+		})
+	}
+}
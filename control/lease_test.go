@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func acquireLease(t *testing.T, h http.Handler, id, holder string) string {
+	t.Helper()
+	rec := doRequest(t, h, http.MethodPost, "/nodes/"+id+"/lease", map[string]string{"holder": holder}, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("lease %s for %s: status %d: %s", id, holder, rec.Code, rec.Body)
+	}
+	var resp struct {
+		Token string `json:"lease_token"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode lease: %v", err)
+	}
+	return resp.Token
+}
+
+func leaseHeader(token string) http.Header {
+	return http.Header{http.CanonicalHeaderKey(leaseTokenHeader): {token}}
+}
+
+func TestReserveRetryAfterLeaseChangesHands(t *testing.T) {
+	_, clock, h := newTestServer(t)
+	id := registerNode(t, h, testRegisterRequest("leased", "10.0.0.1")).NodeID
+	token := acquireLease(t, h, id, "sched-a")
+
+	hdr := leaseHeader(token)
+	hdr.Set(idemHeader, "job-1")
+	if rec := doRequest(t, h, http.MethodPost, "/nodes/"+id+"/reserve", nil, hdr); rec.Code != http.StatusOK {
+		t.Fatalf("reserve: status %d: %s", rec.Code, rec.Body)
+	}
+
+	clock.Advance(defaultLeaseTTL) // a's lease lapses and b takes over
+	acquireLease(t, h, id, "sched-b")
+
+	rec := doRequest(t, h, http.MethodPost, "/nodes/"+id+"/reserve", nil, hdr)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("retried reserve: status %d, want the stored 200: %s", rec.Code, rec.Body)
+	}
+	if got := getNode(t, h, id).JobsRunning; got != 1 {
+		t.Fatalf("jobs_running %d after a retry, want 1", got)
+	}
+
+	hdr.Set(idemHeader, "job-2")
+	if rec := doRequest(t, h, http.MethodPost, "/nodes/"+id+"/reserve", nil, hdr); rec.Code != http.StatusConflict {
+		t.Fatalf("new reserve with a stale lease: status %d, want 409", rec.Code)
+	}
+}
+
+func TestReserveNeedsLease(t *testing.T) {
+	reg, _, h := newTestServer(t)
+	id := registerNode(t, h, testRegisterRequest("free", "10.0.0.1")).NodeID
+
+	rec := doRequest(t, h, http.MethodPost, "/nodes/"+id+"/reserve", nil, nil)
+	if rec.Code != http.StatusConflict || decodeErrorCode(t, rec) != "lease_required" {
+		t.Fatalf("unleased reserve: status %d, want 409 lease_required", rec.Code)
+	}
+	token := acquireLease(t, h, id, "sched-a")
+	if rec := doRequest(t, h, http.MethodPost, "/nodes/"+id+"/reserve", nil, leaseHeader("not-it")); rec.Code != http.StatusConflict {
+		t.Fatalf("reserve with the wrong token: status %d, want 409", rec.Code)
+	}
+	if rec := doRequest(t, h, http.MethodPost, "/nodes/"+id+"/reserve", nil, leaseHeader(token)); rec.Code != http.StatusOK {
+		t.Fatalf("reserve with the lease: status %d: %s", rec.Code, rec.Body)
+	}
+
+	// LEGION_UNLEASED_RESERVE only opens up nodes nobody holds
+	reg.unleasedReserve = true
+	other := registerNode(t, h, testRegisterRequest("other", "10.0.0.2")).NodeID
+	if rec := doRequest(t, h, http.MethodPost, "/nodes/"+other+"/reserve", nil, nil); rec.Code != http.StatusOK {
+		t.Fatalf("unleased reserve with the opt-in: status %d: %s", rec.Code, rec.Body)
+	}
+	if rec := doRequest(t, h, http.MethodPost, "/nodes/"+id+"/reserve", nil, nil); rec.Code != http.StatusConflict {
+		t.Fatalf("reserve on a leased node with the opt-in: status %d, want 409", rec.Code)
+	}
+}
+
+func TestScheduleSkipsNodesLeasedToOthers(t *testing.T) {
+	_, _, h := newTestServer(t)
+	id := registerNode(t, h, testRegisterRequest("taken", "10.0.0.1")).NodeID
+	token := acquireLease(t, h, id, "sched-a")
+
+	if rec := doRequest(t, h, http.MethodPost, "/schedule", ScheduleRequest{}, nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("schedule without the lease: status %d, want 404: %s", rec.Code, rec.Body)
+	}
+	for name, q := range map[string]ScheduleRequest{"schedule": {}, "rank": {Weights: &ScheduleWeights{FreeSlots: 1}}} {
+		rec := doRequest(t, h, http.MethodPost, "/schedule", q, leaseHeader(token))
+		var resp ScheduleResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || resp.NodeID != id {
+			t.Fatalf("%s with the lease: status %d, got %+v, want %s", name, rec.Code, resp, id)
+		}
+	}
+}
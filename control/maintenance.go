@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// ---------- Maintenance mode ----------
+// A node in maintenance keeps heartbeating and shows in /nodes, but is
+// never handed new work.
+func (r *Registry) SetMaintenance(id string, enabled bool) (NodeRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n, ok := r.nodes[id]
+	if !ok {
+		return NodeRecord{}, errUnknownNode
+	}
+	if n.Maintenance != enabled {
+		n.Maintenance = enabled
+		r.touchLocked(n)
+		r.auditLocked("maintenance", id, "enabled="+strconv.FormatBool(enabled))
+		r.hub.publish("maintenance", r.snapshotLocked(n))
+	}
+	return r.snapshotLocked(n), nil
+}
+
+// POST /nodes/{id}/maintenance {"enabled": true}
+func maintenanceHandler(reg *Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+		if !requireKey(w, r) {
+			return
+		}
+		id := r.PathValue("id")
+		logNodeID(r, id)
+
+		var body struct {
+			Enabled *bool `json:"enabled"`
+		}
+		if !decodeJSON(w, r, heartbeatBodyLimit, &body) {
+			return
+		}
+		if body.Enabled == nil {
+			writeError(w, http.StatusBadRequest, "bad_request", "enabled required")
+			return
+		}
+
+		node, err := reg.SetMaintenance(id, *body.Enabled)
+		if err != nil {
+			writeErrorFor(w, http.StatusNotFound, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"node_id":     node.NodeID,
+			"maintenance": node.Maintenance,
+		})
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestReserveRefusedInMaintenance(t *testing.T) {
+	_, _, h := newTestServer(t)
+	id := registerNode(t, h, testRegisterRequest("maint", "10.0.0.1")).NodeID
+	lease := leaseHeader(acquireLease(t, h, id, "sched"))
+
+	if rec := doRequest(t, h, http.MethodPost, "/nodes/"+id+"/maintenance", map[string]bool{"enabled": true}, nil); rec.Code != http.StatusOK {
+		t.Fatalf("maintenance on: status %d: %s", rec.Code, rec.Body)
+	}
+	rec := doRequest(t, h, http.MethodPost, "/nodes/"+id+"/reserve", nil, lease)
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("reserve in maintenance: status %d, want 409", rec.Code)
+	}
+	if code := decodeErrorCode(t, rec); code != "not_schedulable" {
+		t.Fatalf("reserve in maintenance: code %q, want not_schedulable", code)
+	}
+
+	doRequest(t, h, http.MethodPost, "/nodes/"+id+"/maintenance", map[string]bool{"enabled": false}, nil)
+	if rec := doRequest(t, h, http.MethodPost, "/nodes/"+id+"/reserve", nil, lease); rec.Code != http.StatusOK {
+		t.Fatalf("reserve after maintenance: status %d: %s", rec.Code, rec.Body)
+	}
+}
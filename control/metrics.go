@@ -0,0 +1,65 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ---------- Metrics ----------
+// Served at /metrics via promhttp on the default Prometheus registry.
+var (
+	registerRequestsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "legion_register_requests_total",
+		Help: "Registration requests received.",
+	})
+	heartbeatsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "legion_heartbeats_total",
+		Help: "Agent heartbeats received.",
+	})
+
+	nodesTotalDesc   = prometheus.NewDesc("legion_nodes_total", "Nodes in the registry.", nil, nil)
+	nodesOnlineDesc  = prometheus.NewDesc("legion_nodes_online", "Nodes currently online.", nil, nil)
+	nodesStaleDesc   = prometheus.NewDesc("legion_nodes_stale", "Nodes currently stale.", nil, nil)
+	nodesOfflineDesc = prometheus.NewDesc("legion_nodes_offline", "Nodes currently offline.", nil, nil)
+	totalPowerDesc   = prometheus.NewDesc("legion_total_power_watts", "Sum of reported power_w across all nodes.", nil, nil)
+)
+
+// registryCollector reads gauges straight from the registry at scrape time.
+type registryCollector struct {
+	reg *Registry
+}
+
+func (registryCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- nodesTotalDesc
+	ch <- nodesOnlineDesc
+	ch <- nodesStaleDesc
+	ch <- nodesOfflineDesc
+	ch <- totalPowerDesc
+}
+
+func (c registryCollector) Collect(ch chan<- prometheus.Metric) {
+	var online, stale, offline, power int
+
+	nodes := c.reg.List(nodeFilter{})
+	total := len(nodes)
+	for _, n := range nodes {
+		switch n.Status {
+		case "online":
+			online++
+		case "stale":
+			stale++
+		case "offline":
+			offline++
+		}
+		power += n.PowerW
+	}
+
+	ch <- prometheus.MustNewConstMetric(nodesTotalDesc, prometheus.GaugeValue, float64(total))
+	ch <- prometheus.MustNewConstMetric(nodesOnlineDesc, prometheus.GaugeValue, float64(online))
+	ch <- prometheus.MustNewConstMetric(nodesStaleDesc, prometheus.GaugeValue, float64(stale))
+	ch <- prometheus.MustNewConstMetric(nodesOfflineDesc, prometheus.GaugeValue, float64(offline))
+	ch <- prometheus.MustNewConstMetric(totalPowerDesc, prometheus.GaugeValue, float64(power))
+}
+
+func registerMetrics(reg *Registry) {
+	prometheus.MustRegister(registerRequestsTotal, heartbeatsTotal, registryCollector{reg: reg})
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// ---------- New-node webhook ----------
+// LEGION_NEW_NODE_WEBHOOK gets a JSON POST when a registration creates a
+// brand-new record; re-registrations of a known machine don't fire it.
+// Like alert.go, Register only does a non-blocking channel send and a
+// single goroutine delivers, retrying failures (network errors and 5xx)
+// a few times with backoff.
+const (
+	nodeHookQueueSize = 64
+	nodeHookTimeout   = 5 * time.Second
+	nodeHookAttempts  = 3
+	nodeHookBackoff   = time.Second // doubled per retry
+)
+
+type NodeHookPayload struct {
+	Event string     `json:"event"` // node.registered
+	Time  time.Time  `json:"time"`
+	Node  NodeRecord `json:"node"`
+}
+
+type nodeHook struct {
+	url    string
+	queue  chan NodeHookPayload
+	client *http.Client
+}
+
+// loadNodeHookConfig returns nil when no webhook is configured.
+func loadNodeHookConfig() *nodeHook {
+	url := os.Getenv("LEGION_NEW_NODE_WEBHOOK")
+	if url == "" {
+		return nil
+	}
+	return &nodeHook{
+		url:    url,
+		queue:  make(chan NodeHookPayload, nodeHookQueueSize),
+		client: &http.Client{Timeout: nodeHookTimeout},
+	}
+}
+
+// emit never blocks; called with the registry lock held.
+func (h *nodeHook) emit(ev NodeHookPayload) {
+	select {
+	case h.queue <- ev:
+	default:
+		log.Printf("new-node webhook queue full, dropping %s", ev.Node.NodeID)
+	}
+}
+
+// run delivers queued events until done is closed.
+func (h *nodeHook) run(done <-chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case ev := <-h.queue:
+			h.deliver(ev, done)
+		}
+	}
+}
+
+func (h *nodeHook) deliver(ev NodeHookPayload, done <-chan struct{}) {
+	body, _ := json.Marshal(ev)
+	backoff := nodeHookBackoff
+	for attempt := 1; ; attempt++ {
+		err := h.send(body)
+		if err == nil {
+			return
+		}
+		if attempt == nodeHookAttempts {
+			log.Printf("new-node webhook for %s: giving up after %d attempts: %v", ev.Node.NodeID, attempt, err)
+			return
+		}
+		select {
+		case <-done:
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (h *nodeHook) send(body []byte) error {
+	resp, err := h.client.Post(h.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	if resp.StatusCode >= 300 {
+		log.Printf("new-node webhook: %s (not retried)", resp.Status)
+	}
+	return nil
+}
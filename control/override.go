@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+)
+
+// ---------- Manual status override ----------
+// POST /nodes/{id}/status pins a node's status for incident response,
+// e.g. forcing a misbehaving but heartbeating node "offline".
+//
+// Precedence, highest first:
+//
+//  1. override: while StatusOverride is set, heartbeats, re-registers,
+//     drain toggles and the stale monitor leave Status alone (they still
+//     update LastSeen etc.), and the node is never purged or evicted.
+//  2. draining: a live node shows "draining" rather than "online".
+//  3. liveness: online, then stale and offline as heartbeats stop.
+//
+// Clearing the override ({"status": ""}) re-derives the status from the
+// node's last check-in right away.
+var validStatuses = []string{"online", "draining", "stale", "offline"}
+
+func (r *Registry) SetStatusOverride(id, status string) (NodeRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n, ok := r.nodes[id]
+	if !ok {
+		return NodeRecord{}, errUnknownNode
+	}
+	r.touchLocked(n)
+	if status == "" {
+		n.StatusOverride = false
+		r.auditLocked("override", id, "cleared")
+		r.setStatusLocked(n, r.agedStatusLocked(n, r.clock.Now(), liveStatus(n)))
+	} else {
+		n.StatusOverride = true
+		r.auditLocked("override", id, "status="+status)
+		r.applyStatusLocked(n, status)
+	}
+	r.hub.publish("status", r.snapshotLocked(n))
+	return r.snapshotLocked(n), nil
+}
+
+// POST /nodes/{id}/status {"status": "offline"}; {"status": ""} clears
+func statusOverrideHandler(reg *Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+		if !requireKey(w, r) {
+			return
+		}
+		id := r.PathValue("id")
+		logNodeID(r, id)
+
+		var body struct {
+			Status *string `json:"status"`
+		}
+		if !decodeJSON(w, r, heartbeatBodyLimit, &body) {
+			return
+		}
+		if body.Status == nil || (*body.Status != "" && !slices.Contains(validStatuses, *body.Status)) {
+			writeError(w, http.StatusBadRequest, "bad_request", "status must be one of online, draining, stale, offline, or empty to clear")
+			return
+		}
+
+		node, err := reg.SetStatusOverride(id, *body.Status)
+		if err != nil {
+			writeErrorFor(w, http.StatusNotFound, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"node_id":         node.NodeID,
+			"status":          node.Status,
+			"status_override": node.StatusOverride,
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import "errors"
+
+// ---------- Power budget ----------
+// fleetPowerW is a running sum of PowerW over nodes that are drawing power
+// (online or draining), kept current by setPowerLocked, setStatusLocked and
+// removeLocked. With LEGION_POWER_BUDGET_W set, /schedule won't place a
+// job whose estimated draw would take the fleet over the budget.
+var errPowerBudget = errors.New("power budget exceeded")
+
+type PowerBudget struct {
+	BudgetW   int `json:"budget_w"`
+	UsedW     int `json:"used_w"`
+	HeadroomW int `json:"headroom_w"` // negative when already over
+}
+
+// liveStatusKind reports whether a node in status is up and doing work;
+// that's what draws power and what gets billed (cost.go).
+func liveStatusKind(status string) bool {
+	return status == "online" || status == "draining"
+}
+
+func powerContribution(n *NodeRecord) int {
+	if liveStatusKind(n.Status) {
+		return n.PowerW
+	}
+	return 0
+}
+
+func (r *Registry) setPowerLocked(n *NodeRecord, w int) {
+	r.fleetPowerW -= powerContribution(n)
+	n.PowerW = w
+	r.fleetPowerW += powerContribution(n)
+}
+
+// jobPowerW estimates what one more job on n would draw: the requested
+// figure if the caller knows it, else the node's per-slot share.
+func jobPowerW(n *NodeRecord, requested int) int {
+	if requested > 0 {
+		return requested
+	}
+	return n.PowerW / max(1, n.Capacity.JobsParallel)
+}
+
+// withinBudgetLocked reports whether adding w to the fleet stays within budget.
+func (r *Registry) withinBudgetLocked(w int) bool {
+	return r.powerBudgetW == 0 || r.fleetPowerW+w <= r.powerBudgetW
+}
+
+func (r *Registry) powerBudgetLocked() *PowerBudget {
+	if r.powerBudgetW == 0 {
+		return nil
+	}
+	return &PowerBudget{
+		BudgetW:   r.powerBudgetW,
+		UsedW:     r.fleetPowerW,
+		HeadroomW: r.powerBudgetW - r.fleetPowerW,
+	}
+}
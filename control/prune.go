@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// ---------- Bulk prune ----------
+// POST /nodes/prune deletes every node matching the body in one pass
+// under the registry lock, so the stale monitor can't change a node's
+// status between matching and deleting it.
+type PruneRequest struct {
+	Status       string   `json:"status,omitempty"`
+	OlderThanSec int64    `json:"older_than_sec,omitempty"` // since LastSeen
+	Group        string   `json:"group,omitempty"`
+	Region       string   `json:"region,omitempty"`
+	Labels       []string `json:"labels,omitempty"`
+}
+
+type PruneResponse struct {
+	Deleted []string `json:"deleted"`
+	Count   int      `json:"count"`
+}
+
+func (q PruneRequest) validate() error {
+	if q.OlderThanSec < 0 {
+		return errors.New("older_than_sec must be >= 0")
+	}
+	if q.Status == "" && q.OlderThanSec == 0 {
+		return errors.New("status or older_than_sec required") // no accidental wipe of the whole fleet
+	}
+	return nil
+}
+
+func (r *Registry) Prune(q PruneRequest) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	f := nodeFilter{Status: q.Status, Group: q.Group, Region: q.Region, Labels: normalizeLabels(q.Labels)}
+	now := r.clock.Now()
+	cutoff := time.Duration(q.OlderThanSec) * time.Second
+	deleted := []string{}
+	for id, n := range r.nodes {
+		if !f.match(n) || now.Sub(n.LastSeen) < cutoff {
+			continue
+		}
+		r.removeLocked(n)
+		r.auditLocked("pruned", id, n.Status+", last seen "+n.LastSeen.Format(time.RFC3339))
+		r.hub.publish("pruned", r.snapshotLocked(n))
+		deleted = append(deleted, id)
+	}
+	sort.Strings(deleted)
+	return deleted
+}
+
+// POST /nodes/prune {"status": "offline", "older_than_sec": 3600}
+func pruneHandler(reg *Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+		if !requireKey(w, r) {
+			return
+		}
+
+		var q PruneRequest
+		if !decodeJSON(w, r, registerBodyLimit, &q) {
+			return
+		}
+		if err := q.validate(); err != nil {
+			writeErrorFor(w, http.StatusBadRequest, err)
+			return
+		}
+
+		deleted := reg.Prune(q)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(PruneResponse{Deleted: deleted, Count: len(deleted)})
+	}
+}
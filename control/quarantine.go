@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ---------- Quarantine ----------
+// Known-bad machines can be blocked from registering for a while. An
+// entry matches by machine_id, hardware fingerprint or hostname+IP; registering
+// while matched fails with 403. Entries expire on their own (checked on
+// lookup and swept by the monitor) and are kept in the state file until
+// then. Existing
+// records are left alone: DELETE /nodes/{id} to drop one as well.
+const (
+	defaultQuarantineTTL = time.Hour
+	maxQuarantineTTL     = 30 * 24 * time.Hour
+	maxQuarantineReason  = 256
+)
+
+var errQuarantined = errors.New("machine is quarantined")
+
+type QuarantineEntry struct {
+	ID          string    `json:"id"` // "machine:<machine_id>", "fp:<fingerprint>" or "host:<hostname>@<ip>"
+	MachineID   string    `json:"machine_id,omitempty"`
+	Fingerprint string    `json:"fingerprint,omitempty"`
+	Hostname    string    `json:"hostname,omitempty"`
+	IP          string    `json:"ip,omitempty"`
+	Reason      string    `json:"reason,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// QuarantineRequest names the machine either directly or via a node_id.
+// For a node the narrowest key it has is used: machine_id, then the
+// fingerprint if it covers a MAC, then hostname+IP. A fingerprint of CPU
+// model and cores alone is shared by every box of that build.
+type QuarantineRequest struct {
+	NodeID      string `json:"node_id,omitempty"`
+	MachineID   string `json:"machine_id,omitempty"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+	Hostname    string `json:"hostname,omitempty"`
+	IP          string `json:"ip,omitempty"`
+	TTLSec      int    `json:"ttl_sec,omitempty"` // default 1h, max 30d
+	Reason      string `json:"reason,omitempty"`
+}
+
+func quarantineMachineID(id string) string     { return "machine:" + id }
+func quarantineFingerprintID(fp string) string { return "fp:" + fp }
+func quarantineHostID(host, ip string) string  { return "host:" + host + "@" + ip }
+
+// quarantinedLocked reports whether a registration for req (with
+// fingerprint fp and an already-normalized req.IP) is blocked.
+func (r *Registry) quarantinedLocked(req RegisterRequest, fp string) bool {
+	now := r.clock.Now()
+	ids := []string{quarantineHostID(req.Hostname, req.IP)}
+	if fp != "" {
+		ids = append(ids, quarantineFingerprintID(fp))
+	}
+	if req.MachineID != "" {
+		ids = append(ids, quarantineMachineID(req.MachineID))
+	}
+	for _, id := range ids {
+		if e, ok := r.quarantine[id]; ok && now.Before(e.ExpiresAt) {
+			return true
+		}
+	}
+	return false
+}
+
+// quarantineExpireLocked runs from the sweep.
+func (r *Registry) quarantineExpireLocked(now time.Time) {
+	for id, e := range r.quarantine {
+		if !now.Before(e.ExpiresAt) {
+			delete(r.quarantine, id)
+		}
+	}
+}
+
+func (r *Registry) Quarantine(q QuarantineRequest, ttl time.Duration) (QuarantineEntry, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e := QuarantineEntry{MachineID: q.MachineID, Fingerprint: q.Fingerprint, Hostname: q.Hostname, IP: q.IP, Reason: q.Reason}
+	if q.NodeID != "" {
+		n, ok := r.nodes[q.NodeID]
+		if !ok {
+			return QuarantineEntry{}, errUnknownNode
+		}
+		e.Hostname, e.IP = n.Hostname, n.ReportedIP
+		switch {
+		case n.MachineID != "":
+			e.MachineID = n.MachineID
+		case n.MAC != "":
+			e.Fingerprint = n.Fingerprint
+		}
+	}
+	switch {
+	case e.MachineID != "":
+		e.ID, e.Hostname, e.IP = quarantineMachineID(e.MachineID), "", ""
+	case e.Fingerprint != "":
+		e.ID, e.Hostname, e.IP = quarantineFingerprintID(e.Fingerprint), "", ""
+	default:
+		e.ID = quarantineHostID(e.Hostname, e.IP)
+	}
+	e.CreatedAt = r.clock.Now().UTC()
+	e.ExpiresAt = e.CreatedAt.Add(ttl)
+	r.quarantine[e.ID] = e
+	r.auditLocked("quarantine", q.NodeID, "added "+e.ID+" until "+e.ExpiresAt.Format(time.RFC3339))
+	return e, nil
+}
+
+func (r *Registry) Unquarantine(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.quarantine[id]; !ok {
+		return false
+	}
+	delete(r.quarantine, id)
+	r.auditLocked("quarantine", "", "removed "+id)
+	return true
+}
+
+// QuarantineList returns live entries, soonest to expire first.
+func (r *Registry) QuarantineList() []QuarantineEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.clock.Now()
+	out := []QuarantineEntry{}
+	for _, e := range r.quarantine {
+		if now.Before(e.ExpiresAt) {
+			out = append(out, e)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ExpiresAt.Before(out[j].ExpiresAt) })
+	return out
+}
+
+// RestoreQuarantine installs entries loaded by state.go, minus any that
+// expired while the server was down.
+func (r *Registry) RestoreQuarantine(entries []QuarantineEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.clock.Now()
+	for _, e := range entries {
+		if now.Before(e.ExpiresAt) {
+			r.quarantine[e.ID] = e
+		}
+	}
+}
+
+// validate normalizes q and returns the TTL to apply.
+func (q *QuarantineRequest) validate() (time.Duration, error) {
+	q.MachineID = strings.TrimSpace(q.MachineID)
+	q.Fingerprint = strings.ToLower(strings.TrimSpace(q.Fingerprint))
+	q.Hostname = strings.TrimSpace(q.Hostname)
+	q.Reason = strings.TrimSpace(q.Reason)
+	if q.IP != "" {
+		ip := parseHostIP(q.IP)
+		if ip == nil {
+			return 0, errors.New("ip must be an IP address")
+		}
+		q.IP = ip.String()
+	}
+	named := 0
+	for _, set := range []bool{q.NodeID != "", q.MachineID != "", q.Fingerprint != "", q.Hostname != "" || q.IP != ""} {
+		if set {
+			named++
+		}
+	}
+	switch {
+	case named != 1:
+		return 0, errors.New("exactly one of node_id, machine_id, fingerprint or hostname+ip required")
+	case (q.Hostname == "") != (q.IP == ""):
+		return 0, errors.New("hostname and ip must be given together")
+	case len(q.Reason) > maxQuarantineReason:
+		return 0, errors.New("reason longer than 256 bytes")
+	}
+	ttl := defaultQuarantineTTL
+	if q.TTLSec != 0 {
+		ttl = time.Duration(q.TTLSec) * time.Second
+	}
+	if ttl <= 0 || ttl > maxQuarantineTTL {
+		return 0, errors.New("ttl_sec must be 1..2592000")
+	}
+	return ttl, nil
+}
+
+// /quarantine — GET lists entries, POST adds one
+func quarantineHandler(reg *Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodGet, http.MethodPost)
+			return
+		}
+		if !requireKey(w, r) {
+			return
+		}
+		if r.Method == http.MethodGet {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(reg.QuarantineList())
+			return
+		}
+
+		var q QuarantineRequest
+		if !decodeJSON(w, r, registerBodyLimit, &q) {
+			return
+		}
+		ttl, err := q.validate()
+		if err != nil {
+			writeErrorFor(w, http.StatusBadRequest, err)
+			return
+		}
+		if q.NodeID != "" {
+			logNodeID(r, q.NodeID)
+		}
+		e, err := reg.Quarantine(q, ttl)
+		if err != nil {
+			writeErrorFor(w, http.StatusNotFound, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(e)
+	}
+}
+
+// DELETE /quarantine/{id}
+func unquarantineHandler(reg *Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			methodNotAllowed(w, http.MethodDelete)
+			return
+		}
+		if !requireKey(w, r) {
+			return
+		}
+		if !reg.Unquarantine(r.PathValue("id")) {
+			writeError(w, http.StatusNotFound, "not_found", "no such quarantine entry")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func quarantineNode(t *testing.T, h http.Handler, id string) QuarantineEntry {
+	t.Helper()
+	rec := doRequest(t, h, http.MethodPost, "/quarantine", QuarantineRequest{NodeID: id}, nil)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("quarantine %s: status %d: %s", id, rec.Code, rec.Body)
+	}
+	var e QuarantineEntry
+	if err := json.NewDecoder(rec.Body).Decode(&e); err != nil {
+		t.Fatalf("decode entry: %v", err)
+	}
+	return e
+}
+
+func TestQuarantineKeysOnDistinctiveIdentity(t *testing.T) {
+	_, _, h := newTestServer(t)
+	registerStatus := func(req RegisterRequest) int {
+		return doRequest(t, h, http.MethodPost, "/register", req, nil).Code
+	}
+
+	// same hardware build, no MAC or machine_id: only hostname+IP is safe
+	bad := testRegisterRequest("bad", "10.0.0.1")
+	twin := testRegisterRequest("twin", "10.0.0.2")
+	e := quarantineNode(t, h, registerNode(t, h, bad).NodeID)
+	if e.ID != quarantineHostID("bad", "10.0.0.1") {
+		t.Fatalf("entry %q, want hostname+ip", e.ID)
+	}
+	if code := registerStatus(bad); code != http.StatusForbidden {
+		t.Fatalf("quarantined host: status %d, want 403", code)
+	}
+	if code := registerStatus(twin); code != http.StatusOK {
+		t.Fatalf("same-build twin: status %d, want 200", code)
+	}
+
+	// a MAC makes the fingerprint specific enough to follow a renamed box
+	withMAC := testRegisterRequest("nic", "10.0.0.3")
+	withMAC.MAC = "aa:bb:cc:dd:ee:ff"
+	e = quarantineNode(t, h, registerNode(t, h, withMAC).NodeID)
+	if e.ID != quarantineFingerprintID(withMAC.Fingerprint()) {
+		t.Fatalf("entry %q, want fingerprint", e.ID)
+	}
+	withMAC.Hostname = "nic-renamed"
+	if code := registerStatus(withMAC); code != http.StatusForbidden {
+		t.Fatalf("renamed box with quarantined MAC: status %d, want 403", code)
+	}
+
+	// machine_id beats both
+	withID := testRegisterRequest("mid", "10.0.0.4")
+	withID.MachineID = "m-42"
+	e = quarantineNode(t, h, registerNode(t, h, withID).NodeID)
+	if e.ID != quarantineMachineID("m-42") {
+		t.Fatalf("entry %q, want machine_id", e.ID)
+	}
+	withID.Hostname, withID.IP = "mid-moved", "10.0.0.5"
+	if code := registerStatus(withID); code != http.StatusForbidden {
+		t.Fatalf("moved machine: status %d, want 403", code)
+	}
+	if code := registerStatus(testRegisterRequest("other", "10.0.0.6")); code != http.StatusOK {
+		t.Fatalf("unrelated node: status %d, want 200", code)
+	}
+}
+
+func TestQuarantineSurvivesRestart(t *testing.T) {
+	reg, clock, h := newTestServer(t)
+	long := testRegisterRequest("long", "10.0.0.1")
+	short := testRegisterRequest("short", "10.0.0.2")
+	for _, q := range []QuarantineRequest{
+		{Hostname: long.Hostname, IP: long.IP, TTLSec: 7200},
+		{Hostname: short.Hostname, IP: short.IP, TTLSec: 60},
+	} {
+		if rec := doRequest(t, h, http.MethodPost, "/quarantine", q, nil); rec.Code != http.StatusCreated {
+			t.Fatalf("quarantine %s: status %d: %s", q.Hostname, rec.Code, rec.Body)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := saveState(reg, path); err != nil {
+		t.Fatalf("saveState: %v", err)
+	}
+	clock.Advance(time.Hour) // down long enough for the short one to lapse
+	restored := NewRegistry(clock)
+	if err := loadState(restored, path); err != nil {
+		t.Fatalf("loadState: %v", err)
+	}
+	h2 := newMux(restored, 1_000_000, 1_000_000)
+
+	entries := restored.QuarantineList()
+	if len(entries) != 1 || entries[0].ID != quarantineHostID("long", "10.0.0.1") || !entries[0].ExpiresAt.Equal(testEpoch.Add(2*time.Hour)) {
+		t.Fatalf("restored %+v, want only long, expiring at %s", entries, testEpoch.Add(2*time.Hour))
+	}
+	if rec := doRequest(t, h2, http.MethodPost, "/register", long, nil); rec.Code != http.StatusForbidden {
+		t.Fatalf("quarantined host after restart: status %d, want 403", rec.Code)
+	}
+	if rec := doRequest(t, h2, http.MethodPost, "/register", short, nil); rec.Code != http.StatusOK {
+		t.Fatalf("expired entry after restart: status %d, want 200", rec.Code)
+	}
+}
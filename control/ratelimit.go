@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ---------- Rate limiting ----------
+// Token bucket per public IP. Buckets idle long enough to have refilled
+// are dropped so the map can't grow without bound.
+const (
+	defaultRatePerMin = 60
+	defaultRateBurst  = 20
+)
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type rateLimiter struct {
+	mu        sync.Mutex
+	clock     Clock
+	perSec    float64
+	burst     float64
+	idleTTL   time.Duration
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func newRateLimiter(clock Clock, perMin, burst int) *rateLimiter {
+	perSec := float64(perMin) / 60
+	refill := time.Duration(float64(burst) / perSec * float64(time.Second))
+	return &rateLimiter{
+		clock:   clock,
+		perSec:  perSec,
+		burst:   float64(burst),
+		idleTTL: max(refill, 10*time.Minute),
+		buckets: map[string]*bucket{},
+	}
+}
+
+func (l *rateLimiter) Allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.clock.Now()
+	if now.Sub(l.lastSweep) > l.idleTTL {
+		for k, b := range l.buckets {
+			if now.Sub(b.last) > l.idleTTL {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.perSec)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func withRateLimit(l *rateLimiter, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !l.Allow(getPublicIP(r)) {
+			writeError(w, http.StatusTooManyRequests, "rate_limited", "rate limit exceeded")
+			return
+		}
+		next(w, r)
+	}
+}
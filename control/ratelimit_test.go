@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiterRefillsOnClock(t *testing.T) {
+	clock := newFakeClock(testEpoch)
+	l := newRateLimiter(clock, 60, 2)
+
+	if !l.Allow("a") || !l.Allow("a") {
+		t.Fatal("burst of 2 should be allowed")
+	}
+	if l.Allow("a") {
+		t.Fatal("third request within the burst window allowed")
+	}
+	if !l.Allow("b") {
+		t.Fatal("buckets should be per key")
+	}
+
+	clock.Advance(time.Second)
+	if !l.Allow("a") {
+		t.Fatal("one token should refill after a second at 60/min")
+	}
+	if l.Allow("a") {
+		t.Fatal("only one token should have refilled")
+	}
+}
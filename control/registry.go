@@ -0,0 +1,611 @@
+package main
+
+import (
+	"errors"
+	"hash/fnv"
+	"log"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ---------- Registry ----------
+var (
+	errUnknownNode   = errors.New("unknown node_id")
+	errNoCapacity    = errors.New("no free job slots")
+	errNoReservation = errors.New("no jobs reserved")
+	errUnschedulable = errors.New("node is not accepting new jobs")
+	errRegistryFull  = errors.New("registry full")
+	errHostnameTaken = errors.New("hostname already registered by another online machine")
+)
+
+// Registry owns the node map and the mutex that guards it. Handlers and
+// background loops share one *Registry instead of package globals.
+type Registry struct {
+	mu      sync.Mutex
+	nodes   map[string]*NodeRecord
+	clock   Clock
+	hub     eventHub
+	newID   func(n int) (string, error) // randomID; tests swap it to force collisions
+	started time.Time                   // for /stats uptime
+
+	heartbeatInterval int // seconds
+	staleAfter        time.Duration
+	offlineAfter      time.Duration
+	purgeAfter        time.Duration // 0 = never purge
+	registerGrace     time.Duration // sweep ignores nodes registered this recently
+	heartbeatDebounce time.Duration // repeats inside this only refresh LastSeen; 0 = off
+	powerHistorySize  int
+	powerBudgetW      int // 0 = no budget
+	fleetPowerW       int // see power.go
+	maxNodes          int // 0 = unlimited
+	idem              map[string]idemResult
+	quarantine        map[string]QuarantineEntry // by QuarantineEntry.ID
+	usage             map[string][]usageSpan     // by node_id, outlives the record; see cost.go
+	evictOnFull       bool
+	uniqueHostnames   bool
+	unleasedReserve   bool // reserve without a lease on unleased nodes, see lease.go
+
+	audit     []AuditEvent
+	auditSize int
+	auditFile *auditFile // nil unless LEGION_AUDIT_FILE is set
+	nodeHook  *nodeHook  // nil unless LEGION_NEW_NODE_WEBHOOK is set
+}
+
+func NewRegistry(clock Clock) *Registry {
+	hb := time.Duration(defaultHeartbeatSec) * time.Second
+	return &Registry{
+		nodes:             map[string]*NodeRecord{},
+		idem:              map[string]idemResult{},
+		quarantine:        map[string]QuarantineEntry{},
+		usage:             map[string][]usageSpan{},
+		clock:             clock,
+		newID:             randomID,
+		started:           clock.Now().UTC(),
+		heartbeatInterval: defaultHeartbeatSec,
+		staleAfter:        defaultStaleMultiplier * hb,
+		offlineAfter:      defaultOfflineMultiplier * hb,
+		registerGrace:     hb * 3 / 2,
+		heartbeatDebounce: defaultHeartbeatDebounce,
+		powerHistorySize:  defaultPowerHistorySize,
+		auditSize:         defaultAuditSize,
+	}
+}
+
+func (r *Registry) Now() time.Time { return r.clock.Now() }
+
+func (r *Registry) HeartbeatInterval() int { return r.heartbeatInterval }
+
+// JitterSec is an advisory per-node offset of up to a tenth of the
+// interval, so agents started together don't heartbeat in lockstep.
+// Derived from the node ID, so it stays put across restarts.
+func (r *Registry) JitterSec(id string) int {
+	spread := r.heartbeatInterval / 10
+	if spread == 0 {
+		return 0
+	}
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	return int(h.Sum32() % uint32(spread+1))
+}
+
+// Register creates or refreshes the record for req and returns a copy
+// along with a freshly issued node token (re-registering rotates it).
+func (r *Registry) Register(req RegisterRequest, publicIP string) (NodeRecord, string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	token, err := randomID(16)
+	if err != nil {
+		return NodeRecord{}, "", err
+	}
+
+	fp := req.Fingerprint()
+	if r.quarantinedLocked(req, fp) {
+		return NodeRecord{}, "", errQuarantined
+	}
+	var node *NodeRecord
+	if !req.ForceNew {
+		node = r.findLocked(req, fp)
+	}
+	created := node == nil
+	if r.uniqueHostnames && r.hostnameTakenLocked(req, node) {
+		return NodeRecord{}, "", errHostnameTaken
+	}
+	if node != nil {
+		if err := checkLabelCap("labels", req.Labels, node.ServerLabels); err != nil {
+			return NodeRecord{}, "", err
+		}
+	}
+	// force_new (e.g. after a reimage) leaves any old record to age out
+	if node == nil {
+		if err := r.makeRoomLocked(); err != nil {
+			return NodeRecord{}, "", err
+		}
+		id, err := r.newNodeIDLocked()
+		if err != nil {
+			return NodeRecord{}, "", err
+		}
+		node = &NodeRecord{NodeID: id}
+		r.nodes[id] = node
+		r.auditLocked("registered", node.NodeID, req.Hostname+" "+req.IP)
+	} else {
+		r.auditLocked("registered", node.NodeID, "re-registered "+req.Hostname+" "+req.IP)
+	}
+
+	r.accrueUsageLocked(node, r.clock.Now().UTC()) // time so far is billed at the old rate
+	node.MachineID = req.MachineID
+	node.MAC = req.MAC
+	node.Fingerprint = fp
+	node.Hostname = req.Hostname
+	node.ReportedIP = req.IP
+	node.PublicIP = publicIP
+	node.OS = req.OS
+	node.Arch = req.Arch
+	node.AgentVersion = req.AgentVersion
+	node.CPU = req.CPU
+	node.GPU = req.GPU
+	node.RAMGB = req.RAMGB
+	node.DiskTotalGB = req.DiskTotalGB
+	node.DiskFreeGB = req.DiskFreeGB
+	node.UptimeSec = req.UptimeSec
+	r.setPowerLocked(node, req.PowerW)
+	node.Capacity = req.Capacity
+	node.AgentLabels = req.Labels
+	node.mergeLabels()
+	node.Meta = req.Meta
+	node.Group = req.Group
+	node.CostCenter = req.CostCenter
+	node.PricePerHour = req.PricePerHour
+	node.Runtime = req.Runtime
+	node.Region = ""
+	if req.Region != nil {
+		node.Region = *req.Region
+	}
+	node.RegisteredAt = r.clock.Now().UTC()
+	node.LastSeen = node.RegisteredAt
+	r.setStatusLocked(node, liveStatus(node))
+	r.checkIPConflictLocked(node)
+
+	node.tokenHash = hashToken(token)
+	r.touchLocked(node)
+
+	r.hub.publish("registered", r.snapshotLocked(node))
+	if created && r.nodeHook != nil {
+		r.nodeHook.emit(NodeHookPayload{Event: "node.registered", Time: node.RegisteredAt, Node: r.snapshotLocked(node)})
+	}
+	return r.snapshotLocked(node), token, nil
+}
+
+// makeRoomLocked enforces LEGION_MAX_NODES for a brand-new node. It only
+// ever runs on the create path, so re-registrations are never refused.
+// With eviction on, the stale/offline node seen longest ago makes way;
+// online and draining nodes are never evicted.
+func (r *Registry) makeRoomLocked() error {
+	if r.maxNodes == 0 || len(r.nodes) < r.maxNodes {
+		return nil
+	}
+	if !r.evictOnFull {
+		return errRegistryFull
+	}
+	var victim *NodeRecord
+	for _, n := range r.nodes {
+		if n.Status != "stale" && n.Status != "offline" || n.StatusOverride {
+			continue
+		}
+		if victim == nil || n.LastSeen.Before(victim.LastSeen) {
+			victim = n
+		}
+	}
+	if victim == nil {
+		return errRegistryFull
+	}
+	r.removeLocked(victim)
+	r.auditLocked("evicted", victim.NodeID, "registry full, last seen "+victim.LastSeen.Format(time.RFC3339))
+	r.hub.publish("evicted", r.snapshotLocked(victim))
+	return nil
+}
+
+const nodeIDAttempts = 5
+
+// newNodeIDLocked picks an ID not already in the registry. With 64 random
+// bits a retry should never happen, but it costs nothing to check.
+func (r *Registry) newNodeIDLocked() (string, error) {
+	for range nodeIDAttempts {
+		id, err := r.newID(8)
+		if err != nil {
+			return "", err
+		}
+		if _, taken := r.nodes[id]; !taken {
+			return id, nil
+		}
+	}
+	return "", errors.New("could not allocate a unique node_id")
+}
+
+// checkIPConflictLocked flags node and any other online node reporting the
+// same IP. Diagnostic only; registration still goes ahead.
+func (r *Registry) checkIPConflictLocked(node *NodeRecord) {
+	node.Conflict = false
+	if node.ReportedIP == "" {
+		return
+	}
+	for _, n := range r.nodes {
+		if n == node || n.Status != "online" || n.ReportedIP != node.ReportedIP {
+			continue
+		}
+		log.Printf("warning: ip conflict: %s reported by %s (%s) and %s (%s)",
+			node.ReportedIP, node.NodeID, node.Hostname, n.NodeID, n.Hostname)
+		n.Conflict = true
+		node.Conflict = true
+	}
+}
+
+// hostnameTakenLocked reports whether another online node (not self, the
+// record req is about to refresh) already uses req's hostname under a
+// different machine_id.
+func (r *Registry) hostnameTakenLocked(req RegisterRequest, self *NodeRecord) bool {
+	for _, n := range r.nodes {
+		if n != self && n.Status == "online" && n.Hostname == req.Hostname && n.MachineID != req.MachineID {
+			return true
+		}
+	}
+	return false
+}
+
+// findLocked returns the existing record for req, if any. A machine_id
+// match wins; otherwise fall back to hostname + reported IP among records
+// that never sent a machine_id (older agents, or the first upgraded boot).
+// In the fallback a differing hardware fingerprint, when both sides sent
+// a MAC, means a different machine that happens to share a hostname, so
+// it gets its own record.
+// force_new can leave several matches behind; the newest one is returned.
+func (r *Registry) findLocked(req RegisterRequest, fp string) *NodeRecord {
+	var best *NodeRecord
+	if req.MachineID != "" {
+		for _, n := range r.nodes {
+			if n.MachineID == req.MachineID {
+				best = newerRecord(best, n)
+			}
+		}
+		if best != nil {
+			return best
+		}
+	}
+	for _, n := range r.nodes {
+		if n.MachineID != "" || n.Hostname != req.Hostname || n.ReportedIP != req.IP {
+			continue
+		}
+		// without a MAC on both sides the fingerprint is just CPU model and
+		// cores, which shift with an agent upgrade or an SMT toggle
+		if req.MAC != "" && n.MAC != "" && fp != n.Fingerprint {
+			continue
+		}
+		best = newerRecord(best, n)
+	}
+	return best
+}
+
+// newerRecord returns whichever of a and b was seen last, falling back to
+// registration time; a may be nil.
+func newerRecord(a, b *NodeRecord) *NodeRecord {
+	switch {
+	case a == nil, b.LastSeen.After(a.LastSeen):
+		return b
+	case b.LastSeen.Equal(a.LastSeen) && b.RegisteredAt.After(a.RegisteredAt):
+		return b
+	}
+	return a
+}
+
+// Heartbeat refreshes a known node. ok is false for an unknown node_id.
+func (r *Registry) Heartbeat(hb AgentHeartbeat) (NodeRecord, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	node, ok := r.nodes[hb.NodeID]
+	if !ok {
+		return NodeRecord{}, false
+	}
+
+	now := r.clock.Now().UTC()
+
+	// a misbehaving agent firing bursts of heartbeats still counts as alive,
+	// but only the first of the burst pays for sampling and fan-out
+	if r.heartbeatDebounce > 0 && now.Sub(node.lastBeat) < r.heartbeatDebounce {
+		node.LastSeen = now
+		r.touchLocked(node)
+		return r.snapshotLocked(node), true
+	}
+	node.lastBeat = now
+
+	// Optional live updates
+	if hb.UptimeSec > 0 {
+		r.applyUptimeLocked(node, hb.UptimeSec, now)
+	}
+	if hb.PowerW > 0 {
+		r.setPowerLocked(node, hb.PowerW)
+	}
+	if hb.DiskFreeGB != nil && *hb.DiskFreeGB >= 0 {
+		node.DiskFreeGB = *hb.DiskFreeGB
+	}
+	if len(hb.GPUs) > 0 {
+		var dropped int
+		node.GPU, dropped = applyGPUUsage(node.GPU, hb.GPUs)
+		if dropped > 0 {
+			log.Printf("node %s: %d gpu usage entries matched no registered gpu", node.NodeID, dropped)
+		}
+	}
+	if hb.JobsRunning != nil && *hb.JobsRunning >= 0 && *hb.JobsRunning != node.JobsRunning {
+		if *hb.JobsRunning < node.JobsRunning {
+			log.Printf("node %s: agent reports %d jobs running, freeing %d reserved slots",
+				node.NodeID, *hb.JobsRunning, node.JobsRunning-*hb.JobsRunning)
+		}
+		node.JobsRunning = *hb.JobsRunning
+	}
+	if hb.Capacity != nil && hb.Capacity.JobsParallel >= 0 {
+		// running jobs may briefly exceed a lowered limit; they drain naturally
+		node.Capacity = *hb.Capacity
+	}
+	node.LastSeen = now
+	r.touchLocked(node)
+	r.setStatusLocked(node, liveStatus(node))
+
+	if node.power == nil {
+		node.power = newPowerRing(r.powerHistorySize)
+	}
+	node.power.push(PowerSample{Timestamp: node.LastSeen, PowerW: node.PowerW})
+
+	r.hub.publish("heartbeat", r.snapshotLocked(node))
+	return r.snapshotLocked(node), true
+}
+
+// applyUptimeLocked treats uptime going backwards as a reboot, and ignores
+// uptime that advanced further than wall time since the last contact
+// allows (plus one interval of slack) as clock skew.
+func (r *Registry) applyUptimeLocked(node *NodeRecord, uptime int64, now time.Time) {
+	switch {
+	case uptime < node.UptimeSec:
+		node.RebootCount++
+	case !node.LastSeen.IsZero():
+		elapsed := int64(now.Sub(node.LastSeen).Seconds())
+		if uptime-node.UptimeSec > elapsed+int64(r.heartbeatInterval) {
+			return
+		}
+	}
+	node.UptimeSec = uptime
+}
+
+// List returns copies of every node matching f, ordered by NodeID.
+func (r *Registry) List(f nodeFilter) []NodeRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.listLocked(f)
+}
+
+func (r *Registry) listLocked(f nodeFilter) []NodeRecord {
+	out := make([]NodeRecord, 0, len(r.nodes))
+	for _, n := range r.nodes {
+		if !f.match(n) {
+			continue
+		}
+		out = append(out, r.snapshotLocked(n))
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].NodeID < out[j].NodeID })
+	return out
+}
+
+// touchLocked marks n as changed for conditional GETs. Anything that
+// mutates a field visible in GET /nodes/{id} calls it; LastSeenAgeSec
+// is derived per read and doesn't count.
+func (r *Registry) touchLocked(n *NodeRecord) {
+	n.modified = r.clock.Now().UTC()
+}
+
+// snapshotLocked copies n for use outside the lock. Every record leaving
+// the registry (return values and published events) goes through here, so
+// it also deep-copies anything mutated in place and fills in derived fields.
+func (r *Registry) snapshotLocked(n *NodeRecord) NodeRecord {
+	c := *n
+	c.commands = slices.Clone(n.commands) // DeliverCommands/AckCommand edit the backing array
+	c.Lease = r.activeLeaseLocked(n)      // hide expired leases
+	c.LastSeenAgeSec = int64(r.clock.Now().Sub(n.LastSeen).Seconds())
+	if c.LastSeenAgeSec < 0 {
+		c.LastSeenAgeSec = 0
+	}
+	return c
+}
+
+func (r *Registry) Get(id string) (NodeRecord, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n, ok := r.nodes[id]
+	if !ok {
+		return NodeRecord{}, false
+	}
+	return r.snapshotLocked(n), true
+}
+
+// Delete removes a node, reporting whether it existed.
+func (r *Registry) Delete(id string) bool {
+	return r.remove(id, "deleted")
+}
+
+// Deregister is Delete on the agent's own request (clean shutdown).
+func (r *Registry) Deregister(id string) bool {
+	return r.remove(id, "deregistered")
+}
+
+func (r *Registry) remove(id, reason string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n, ok := r.nodes[id]
+	if !ok {
+		return false
+	}
+	r.removeLocked(n)
+	r.auditLocked(reason, id, n.Hostname)
+	r.hub.publish(reason, r.snapshotLocked(n))
+	return true
+}
+
+// removeLocked drops n from the registry and from the running totals.
+func (r *Registry) removeLocked(n *NodeRecord) {
+	r.accrueUsageLocked(n, r.clock.Now().UTC()) // its usage stays billed
+	r.fleetPowerW -= powerContribution(n)
+	delete(r.nodes, n.NodeID)
+}
+
+// Reserve claims one job slot on a node. A non-empty idemKey makes
+// retries safe, see idempotency.go; leaseToken must be the node's active
+// lease, see lease.go.
+func (r *Registry) Reserve(id, idemKey, leaseToken string) (NodeRecord, error) {
+	return r.reservation(id, idemKey, leaseToken, true)
+}
+
+// Release frees one job slot on a node.
+func (r *Registry) Release(id, idemKey string) (NodeRecord, error) {
+	return r.reservation(id, idemKey, "", false)
+}
+
+func (r *Registry) reservation(id, idemKey, leaseToken string, reserve bool) (NodeRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n, ok := r.nodes[id]
+	if !ok {
+		return NodeRecord{}, errUnknownNode
+	}
+	// a retry of a call that already went through gets its stored result,
+	// even if the lease has since lapsed or changed hands
+	if idemKey != "" {
+		if prev, ok := r.idemLookupLocked(id, idemKey); ok {
+			if prev.reserve != reserve {
+				return NodeRecord{}, errIdemKeyReused
+			}
+			return prev.node, prev.err
+		}
+	}
+	if reserve {
+		if err := r.checkLeaseLocked(n, leaseToken); err != nil {
+			return r.snapshotLocked(n), err
+		}
+	}
+
+	var err error
+	switch {
+	case reserve && !schedulable(n):
+		err = errUnschedulable
+	case reserve && n.JobsRunning+1 > n.Capacity.JobsParallel:
+		err = errNoCapacity
+	case !reserve && n.JobsRunning == 0:
+		err = errNoReservation
+	case reserve:
+		n.JobsRunning++
+		r.touchLocked(n)
+	default:
+		n.JobsRunning--
+		r.touchLocked(n)
+	}
+	node := r.snapshotLocked(n)
+	if idemKey != "" {
+		r.idemStoreLocked(id, idemKey, idemResult{reserve: reserve, node: node, err: err})
+	}
+	return node, err
+}
+
+// Restore loads previously snapshotted nodes as stale.
+func (r *Registry) Restore(nodes []NodeRecord) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.clock.Now().UTC()
+	for i := range nodes {
+		n := nodes[i]
+		if !n.StatusOverride { // a manual status stands until cleared
+			if n.Status != "stale" {
+				n.StatusSince = now
+			}
+			n.Status = "stale" // unknown until it pings again
+		}
+		n.Lease = nil // leases don't survive a restart
+		n.modified = now
+		n.accruedAt = now // the downtime isn't billed
+		if n.AgentLabels == nil && n.ServerLabels == nil {
+			n.AgentLabels = n.Labels // snapshot predates the split
+		}
+		// snapshots from older versions may hold non-canonical addresses
+		n.ReportedIP, n.PublicIP = canonicalIP(n.ReportedIP), canonicalIP(n.PublicIP)
+		r.nodes[n.NodeID] = &n
+		r.fleetPowerW += powerContribution(&n) // nonzero only for an overridden live status
+	}
+}
+
+// online/draining -> stale after staleAfter without a ping, stale -> offline
+// after offlineAfter, and gone entirely after purgeAfter when that's enabled.
+// See drain.go for the full state machine.
+func (r *Registry) SweepStatuses(now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.idemExpireLocked(now)
+	r.quarantineExpireLocked(now)
+	r.usageExpireLocked(now)
+	for id, n := range r.nodes {
+		if now.Sub(n.RegisteredAt) < r.registerGrace {
+			continue // first heartbeat may still be on its way
+		}
+		age := now.Sub(n.LastSeen)
+		if r.purgeAfter > 0 && age > r.purgeAfter && !n.StatusOverride {
+			r.removeLocked(n)
+			log.Printf("purged node %s (%s), last seen %s", id, n.Hostname, n.LastSeen.Format(time.RFC3339))
+			r.auditLocked("purged", id, "last seen "+n.LastSeen.Format(time.RFC3339))
+			r.hub.publish("purged", r.snapshotLocked(n))
+			continue
+		}
+		r.accrueUsageLocked(n, now)
+		if r.setStatusLocked(n, r.agedStatusLocked(n, now, n.Status)) {
+			r.hub.publish("status", r.snapshotLocked(n))
+		}
+	}
+}
+
+const defaultMonitorInterval = 15 * time.Second
+
+// agedStatusLocked is n's status given how long ago it last checked in:
+// fresh while recent, then stale, then offline.
+func (r *Registry) agedStatusLocked(n *NodeRecord, now time.Time, fresh string) string {
+	age := now.Sub(n.LastSeen)
+	switch {
+	case age > r.offlineAfter:
+		return "offline"
+	case age > r.staleAfter:
+		return "stale"
+	}
+	return fresh
+}
+
+// background: mark nodes stale if they stop pinging, every interval until
+// done is closed. alerts may be nil.
+func startStaleMonitor(reg *Registry, done <-chan struct{}, interval time.Duration, alerts *countAlerter) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			now := reg.Now().UTC()
+			reg.SweepStatuses(now)
+			if alerts != nil {
+				alerts.observe(now, reg.Summary())
+			}
+		}
+	}()
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func getNode(t *testing.T, h http.Handler, id string) NodeRecord {
+	t.Helper()
+	rec := doRequest(t, h, http.MethodGet, "/nodes/"+id, nil, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("get node %s: status %d: %s", id, rec.Code, rec.Body)
+	}
+	var n NodeRecord
+	if err := json.NewDecoder(rec.Body).Decode(&n); err != nil {
+		t.Fatalf("decode node: %v", err)
+	}
+	return n
+}
+
+func heartbeat(t *testing.T, h http.Handler, id string) {
+	t.Helper()
+	rec := doRequest(t, h, http.MethodPost, "/agent/heartbeat", AgentHeartbeat{NodeID: id}, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("heartbeat %s: status %d: %s", id, rec.Code, rec.Body)
+	}
+}
+
+func TestStatusAgesOnlineStaleOffline(t *testing.T) {
+	reg, clock, h := newTestServer(t)
+	id := registerNode(t, h, testRegisterRequest("ager", "10.0.0.1")).NodeID
+	heartbeat(t, h, id)
+
+	step := func(d time.Duration, want string) {
+		t.Helper()
+		clock.Advance(d)
+		reg.SweepStatuses(clock.Now())
+		if got := getNode(t, h, id).Status; got != want {
+			t.Fatalf("after %s: status %q, want %q", d, got, want)
+		}
+	}
+	step(0, "online")
+	step(reg.staleAfter-time.Second, "online")
+	step(2*time.Second, "stale")
+	step(reg.offlineAfter-reg.staleAfter, "offline")
+
+	heartbeat(t, h, id)
+	if got := getNode(t, h, id).Status; got != "online" {
+		t.Fatalf("after heartbeat: status %q, want online", got)
+	}
+}
+
+func TestForceNewKeepsOldRecord(t *testing.T) {
+	_, clock, h := newTestServer(t)
+	req := testRegisterRequest("reimaged", "10.0.0.1")
+	req.MachineID = "m-1"
+	old := registerNode(t, h, req).NodeID
+
+	clock.Advance(time.Minute)
+	req.ForceNew = true
+	fresh := registerNode(t, h, req).NodeID
+	if fresh == old {
+		t.Fatal("force_new reused the old node_id")
+	}
+	if nodes := listNodes(t, h); len(nodes) != 2 {
+		t.Fatalf("got %d records after force_new, want 2", len(nodes))
+	}
+
+	// a plain re-registration now belongs to the newest record, however
+	// the map happens to iterate
+	clock.Advance(time.Minute)
+	req.ForceNew = false
+	for range 20 {
+		if got := registerNode(t, h, req).NodeID; got != fresh {
+			t.Fatalf("re-register matched %s, want newest record %s", got, fresh)
+		}
+	}
+}
+
+// Run under -race: handlers and the monitor sweep share one registry.
+func TestConcurrentRegisterHeartbeatListSweep(t *testing.T) {
+	reg, clock, h := newTestServer(t)
+	const workers = 8
+
+	var wg sync.WaitGroup
+	for i := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// t.Fatal must stay on the test goroutine, so only report here
+			rec := doRequest(t, h, http.MethodPost, "/register", testRegisterRequest(fmt.Sprintf("w%d", i), fmt.Sprintf("10.0.1.%d", i)), nil)
+			var resp RegisterResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Errorf("register w%d: status %d: %v", i, rec.Code, err)
+				return
+			}
+			for range 50 {
+				if rec := doRequest(t, h, http.MethodPost, "/agent/heartbeat", AgentHeartbeat{NodeID: resp.NodeID}, nil); rec.Code != http.StatusOK {
+					t.Errorf("heartbeat w%d: status %d", i, rec.Code)
+				}
+				if rec := doRequest(t, h, http.MethodGet, "/nodes", nil, nil); rec.Code != http.StatusOK {
+					t.Errorf("list: status %d", rec.Code)
+				}
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for range 50 {
+			clock.Advance(time.Second)
+			reg.SweepStatuses(clock.Now())
+		}
+	}()
+	wg.Wait()
+
+	if nodes := listNodes(t, h); len(nodes) != workers {
+		t.Fatalf("got %d nodes, want %d", len(nodes), workers)
+	}
+}
+
+func TestNodeIDCollisionRetries(t *testing.T) {
+	reg, _, h := newTestServer(t)
+	first := registerNode(t, h, testRegisterRequest("first", "10.0.0.1")).NodeID
+
+	// hand out the taken ID twice before a fresh one
+	ids := []string{first, first, "fresh"}
+	reg.newID = func(int) (string, error) {
+		id := ids[0]
+		ids = ids[1:]
+		return id, nil
+	}
+	if got := registerNode(t, h, testRegisterRequest("second", "10.0.0.2")).NodeID; got != "fresh" {
+		t.Fatalf("got node_id %q, want the retried %q", got, "fresh")
+	}
+
+	reg.newID = func(int) (string, error) { return first, nil }
+	rec := doRequest(t, h, http.MethodPost, "/register", testRegisterRequest("third", "10.0.0.3"), nil)
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("register with only colliding IDs: status %d, want 500", rec.Code)
+	}
+	if n := len(listNodes(t, h)); n != 2 {
+		t.Fatalf("got %d nodes, want 2", n)
+	}
+}
+
+func TestFingerprintDriftKeepsOneRecord(t *testing.T) {
+	_, _, h := newTestServer(t)
+	req := testRegisterRequest("drifty", "10.0.0.1")
+	id := registerNode(t, h, req).NodeID
+
+	req.CPU.Cores = 16 // SMT toggled
+	if got := registerNode(t, h, req).NodeID; got != id {
+		t.Fatalf("core count change made a new record %s", got)
+	}
+	req.MAC = "aa:bb:cc:dd:ee:01" // agent started sending a MAC
+	if got := registerNode(t, h, req).NodeID; got != id {
+		t.Fatalf("first MAC made a new record %s", got)
+	}
+
+	// with a MAC on both sides a different one is a different machine
+	other := req
+	other.MAC = "aa:bb:cc:dd:ee:02"
+	if got := registerNode(t, h, other).NodeID; got == id {
+		t.Fatal("different MAC matched the existing record")
+	}
+}
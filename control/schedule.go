@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+// ---------- Scheduling ----------
+type ScheduleRequest struct {
+	MinRAMGB      int      `json:"min_ram_gb,omitempty"`
+	MinFreeDiskGB int      `json:"min_free_disk_gb,omitempty"`
+	MinVRAMGB     int      `json:"min_vram_gb,omitempty"`
+	GPUName       string   `json:"gpu_name,omitempty"` // substring, paired with MinVRAMGB on the same GPU
+	Labels        []string `json:"labels,omitempty"`
+	MinFreeSlots  int      `json:"min_free_slots,omitempty"`
+	Region        string   `json:"region,omitempty"`  // preferred, not required
+	PowerW        int      `json:"power_w,omitempty"` // expected job draw, for the power budget
+	Runtime       string   `json:"runtime,omitempty"` // required container runtime
+
+	Weights *ScheduleWeights `json:"weights,omitempty"` // switches to a ranked reply, see score.go
+	Limit   int              `json:"limit,omitempty"`   // ranked entries to return, default 10
+
+	leaseToken string // X-LEGION-LEASE-TOKEN; nodes leased to other holders are skipped
+}
+
+type ScheduleResponse struct {
+	NodeID    string `json:"node_id"`
+	IP        string `json:"ip"`
+	PublicIP  string `json:"public_ip"`
+	FreeSlots int    `json:"free_slots"`
+	Region    string `json:"region,omitempty"`
+}
+
+// ScheduleRankResponse answers a weighted request: the top pick's fields
+// as usual, plus the ranking it came from.
+type ScheduleRankResponse struct {
+	ScheduleResponse
+	Ranked []ScheduleCandidate `json:"ranked"`
+}
+
+func scheduleResponse(n *NodeRecord) ScheduleResponse {
+	return ScheduleResponse{
+		NodeID:    n.NodeID,
+		IP:        n.ReportedIP,
+		PublicIP:  n.PublicIP,
+		FreeSlots: freeSlots(n),
+		Region:    n.Region,
+	}
+}
+
+func freeSlots(n *NodeRecord) int {
+	return n.Capacity.JobsParallel - n.JobsRunning
+}
+
+// fits reports whether n can take a job with these requirements.
+func (q ScheduleRequest) fits(n *NodeRecord) bool {
+	if !schedulable(n) {
+		return false
+	}
+	if freeSlots(n) < max(1, q.MinFreeSlots) {
+		return false
+	}
+	if n.RAMGB < q.MinRAMGB {
+		return false
+	}
+	if n.DiskFreeGB < q.MinFreeDiskGB {
+		return false
+	}
+	if q.Runtime != "" && n.Runtime != normalizeRuntime(q.Runtime) {
+		return false
+	}
+	if !(GPUQuery{Name: q.GPUName, MinVRAMGB: q.MinVRAMGB}).matches(n.GPU) {
+		return false
+	}
+	for _, l := range q.Labels {
+		if !slices.Contains(n.Labels, l) {
+			return false
+		}
+	}
+	return true
+}
+
+// better prefers more free slots, then lower power draw, then NodeID for stability.
+func better(a, b *NodeRecord) bool {
+	if fa, fb := freeSlots(a), freeSlots(b); fa != fb {
+		return fa > fb
+	}
+	if a.PowerW != b.PowerW {
+		return a.PowerW < b.PowerW
+	}
+	return a.NodeID < b.NodeID
+}
+
+var errNoMatch = errors.New("no matching node")
+
+// candidatesLocked returns every node q fits that the power budget can
+// also absorb; overBudget reports whether some were dropped for power.
+// Both Schedule and Rank come through here, so labels are normalized once.
+func (r *Registry) candidatesLocked(q ScheduleRequest) (out []*NodeRecord, overBudget bool) {
+	q.Labels = normalizeLabels(q.Labels)
+	for _, n := range r.nodes {
+		if !q.fits(n) {
+			continue
+		}
+		if l := r.activeLeaseLocked(n); l != nil && !leaseHeldBy(l, q.leaseToken) {
+			continue // another scheduler's; its reserve would 409 anyway
+		}
+		if !r.withinBudgetLocked(jobPowerW(n, q.PowerW)) {
+			overBudget = true
+			continue
+		}
+		out = append(out, n)
+	}
+	return out, overBudget
+}
+
+func noCandidateErr(overBudget bool) error {
+	if overBudget {
+		return errPowerBudget
+	}
+	return errNoMatch
+}
+
+// Schedule picks a node for q. With q.Region set, nodes in that region
+// win outright; other regions are only considered when none there fit.
+// errPowerBudget means some nodes fit but every one of them would push
+// the fleet past LEGION_POWER_BUDGET_W.
+func (r *Registry) Schedule(q ScheduleRequest) (NodeRecord, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cands, overBudget := r.candidatesLocked(q)
+	var best, bestLocal *NodeRecord
+	for _, n := range cands {
+		if best == nil || better(n, best) {
+			best = n
+		}
+		if q.Region != "" && n.Region == q.Region && (bestLocal == nil || better(n, bestLocal)) {
+			bestLocal = n
+		}
+	}
+	if bestLocal != nil {
+		best = bestLocal
+	}
+	if best == nil {
+		return NodeRecord{}, noCandidateErr(overBudget)
+	}
+	return r.snapshotLocked(best), nil
+}
+
+// Rank scores every candidate with q.Weights and returns the best limit,
+// highest score first. Region is only a weighted factor here.
+func (r *Registry) Rank(q ScheduleRequest, limit int) ([]ScheduleCandidate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cands, overBudget := r.candidatesLocked(q)
+	if len(cands) == 0 {
+		return nil, noCandidateErr(overBudget)
+	}
+	nodes := make([]NodeRecord, len(cands))
+	for i, n := range cands {
+		nodes[i] = r.snapshotLocked(n)
+	}
+	ranked := rankCandidates(nodes, q, *q.Weights)
+	return ranked[:min(limit, len(ranked))], nil
+}
+
+func scheduleHandler(reg *Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+		if !requireKey(w, r) {
+			return
+		}
+
+		var q ScheduleRequest
+		if !decodeJSON(w, r, registerBodyLimit, &q) {
+			return
+		}
+		q.leaseToken = r.Header.Get(leaseTokenHeader)
+		if q.Weights != nil {
+			rankHandler(reg, w, r, q)
+			return
+		}
+
+		node, err := reg.Schedule(q)
+		if err != nil {
+			writeScheduleError(w, err)
+			return
+		}
+		logNodeID(r, node.NodeID)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(scheduleResponse(&node))
+	}
+}
+
+func rankHandler(reg *Registry, w http.ResponseWriter, r *http.Request, q ScheduleRequest) {
+	if err := q.Weights.validate(); err != nil {
+		writeErrorFor(w, http.StatusBadRequest, err)
+		return
+	}
+	limit := defaultRankLimit
+	if q.Limit != 0 {
+		limit = q.Limit
+	}
+	if limit < 0 || limit > maxRankLimit {
+		writeError(w, http.StatusBadRequest, "bad_request", fmt.Sprintf("limit must be 1..%d", maxRankLimit))
+		return
+	}
+
+	ranked, err := reg.Rank(q, limit)
+	if err != nil {
+		writeScheduleError(w, err)
+		return
+	}
+	logNodeID(r, ranked[0].NodeID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ScheduleRankResponse{ScheduleResponse: ranked[0].ScheduleResponse, Ranked: ranked})
+}
+
+func writeScheduleError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errPowerBudget) {
+		writeErrorFor(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	writeErrorFor(w, http.StatusNotFound, err)
+}
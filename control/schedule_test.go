@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestScheduleNormalizesLabels(t *testing.T) {
+	_, _, h := newTestServer(t)
+	req := testRegisterRequest("gpu-box", "10.0.0.1")
+	req.Labels = []string{"gpu", "team-a/ml"}
+	id := registerNode(t, h, req).NodeID
+
+	for name, q := range map[string]ScheduleRequest{
+		"schedule": {Labels: []string{" GPU", "Team-A/ML"}},
+		"rank":     {Labels: []string{" GPU", "Team-A/ML"}, Weights: &ScheduleWeights{FreeSlots: 1}},
+	} {
+		rec := doRequest(t, h, http.MethodPost, "/schedule", q, nil)
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: status %d: %s", name, rec.Code, rec.Body)
+			continue
+		}
+		var resp ScheduleResponse
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil || resp.NodeID != id {
+			t.Errorf("%s: got %+v (%v), want node %s", name, resp, err, id)
+		}
+	}
+}
+
+func TestKeyScopePrefixesNormalized(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scopes.json")
+	if err := os.WriteFile(path, []byte(`{"team-a-key": [" Team-A/"]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("LEGION_KEY_SCOPES_FILE", path)
+	prev := keyScopes
+	t.Cleanup(func() { keyScopes = prev })
+	if err := loadKeyScopes(); err != nil {
+		t.Fatalf("loadKeyScopes: %v", err)
+	}
+
+	_, _, h := newTestServer(t)
+	mine := testRegisterRequest("mine", "10.0.0.1")
+	mine.Labels = []string{"team-a/ml"}
+	id := registerNode(t, h, mine).NodeID
+	registerNode(t, h, testRegisterRequest("theirs", "10.0.0.2"))
+	withAPIKey(t, "admin")
+
+	rec := doRequest(t, h, http.MethodGet, "/nodes", nil, http.Header{"X-Legion-Key": {"team-a-key"}})
+	var nodes []NodeRecord
+	if err := json.NewDecoder(rec.Body).Decode(&nodes); err != nil {
+		t.Fatalf("decode nodes: %v", err)
+	}
+	if len(nodes) != 1 || nodes[0].NodeID != id {
+		t.Fatalf("scoped key saw %+v, want only %s", nodes, id)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"k": ["  "]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadKeyScopes(); err == nil {
+		t.Fatal("blank prefix accepted")
+	}
+}
+
+func TestHeartbeatFullDiskLeavesScheduling(t *testing.T) {
+	_, clock, h := newTestServer(t)
+	req := testRegisterRequest("disk", "10.0.0.1")
+	req.DiskTotalGB, req.DiskFreeGB = 100, 50
+	id := registerNode(t, h, req).NodeID
+	q := ScheduleRequest{MinFreeDiskGB: 10}
+	if rec := doRequest(t, h, http.MethodPost, "/schedule", q, nil); rec.Code != http.StatusOK {
+		t.Fatalf("schedule with free disk: status %d: %s", rec.Code, rec.Body)
+	}
+
+	// a heartbeat without the field leaves the figure alone
+	heartbeat(t, h, id)
+	if got := getNode(t, h, id).DiskFreeGB; got != 50 {
+		t.Fatalf("disk_free_gb %d after a heartbeat without it, want 50", got)
+	}
+
+	clock.Advance(time.Minute) // past the heartbeat debounce
+	full := 0
+	if rec := doRequest(t, h, http.MethodPost, "/agent/heartbeat", AgentHeartbeat{NodeID: id, DiskFreeGB: &full}, nil); rec.Code != http.StatusOK {
+		t.Fatalf("heartbeat: status %d: %s", rec.Code, rec.Body)
+	}
+	if got := getNode(t, h, id).DiskFreeGB; got != 0 {
+		t.Fatalf("disk_free_gb %d, want 0", got)
+	}
+	if rec := doRequest(t, h, http.MethodPost, "/schedule", q, nil); rec.Code == http.StatusOK {
+		t.Fatalf("full node still scheduled: %s", rec.Body)
+	}
+}
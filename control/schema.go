@@ -0,0 +1,41 @@
+package main
+
+import "fmt"
+
+// ---------- Payload schema versions ----------
+// Agents stamp RegisterRequest and AgentHeartbeat with schema_version.
+// Older payloads are upgraded one step at a time to the current shape
+// before anything else looks at them; newer ones are refused because we
+// can't know what their fields mean. Unversioned payloads are version 0.
+//
+// To change a payload: bump currentSchemaVersion and append a step that
+// fills in defaults for the fields older agents don't send.
+const currentSchemaVersion = 1
+
+// steps[i] upgrades a version i payload to version i+1.
+var (
+	registerUpgrades = []func(*RegisterRequest){
+		func(*RegisterRequest) {}, // 0 -> 1: same shape, just stamped
+	}
+	heartbeatUpgrades = []func(*AgentHeartbeat){
+		func(*AgentHeartbeat) {}, // 0 -> 1
+	}
+)
+
+func upgradeSchema[T any](v *T, version *int, steps []func(*T)) error {
+	if *version < 0 || *version > currentSchemaVersion {
+		return fmt.Errorf("schema_version %d not supported (server supports up to %d)", *version, currentSchemaVersion)
+	}
+	for ; *version < currentSchemaVersion; *version++ {
+		steps[*version](v)
+	}
+	return nil
+}
+
+func (req *RegisterRequest) upgrade() error {
+	return upgradeSchema(req, &req.SchemaVersion, registerUpgrades)
+}
+
+func (hb *AgentHeartbeat) upgrade() error {
+	return upgradeSchema(hb, &hb.SchemaVersion, heartbeatUpgrades)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+)
+
+// ---------- Key scopes ----------
+// LEGION_KEY_SCOPES_FILE points at a JSON object mapping extra API keys to
+// the label prefixes they may see, e.g. {"team-a-key": ["team-a/"]}.
+// Scoped keys are read-only and only accepted by GET /nodes; LEGION_KEY
+// itself stays unscoped.
+var keyScopes map[string][]string
+
+func loadKeyScopes() error {
+	path := os.Getenv("LEGION_KEY_SCOPES_FILE")
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("LEGION_KEY_SCOPES_FILE: %v", err)
+	}
+	var scopes map[string][]string
+	if err := json.Unmarshal(data, &scopes); err != nil {
+		return fmt.Errorf("LEGION_KEY_SCOPES_FILE: %v", err)
+	}
+	for key, prefixes := range scopes {
+		// labels are stored normalized, so prefixes have to be too
+		prefixes = normalizeLabels(prefixes)
+		if key == "" || len(prefixes) == 0 || slices.Contains(prefixes, "") {
+			return fmt.Errorf("LEGION_KEY_SCOPES_FILE: every key needs at least one non-empty label prefix")
+		}
+		scopes[key] = prefixes
+	}
+	keyScopes = scopes
+	return nil
+}
+
+// requireListKey is requireKey that also accepts scoped keys, returning
+// the caller's label prefixes (nil = unrestricted).
+func requireListKey(w http.ResponseWriter, r *http.Request) ([]string, bool) {
+	if prefixes, ok := keyScopes[r.Header.Get("X-LEGION-KEY")]; ok {
+		return prefixes, true
+	}
+	return nil, requireKey(w, r)
+}
+
+// hasLabelPrefix reports whether any label starts with one of prefixes.
+func hasLabelPrefix(labels, prefixes []string) bool {
+	for _, l := range labels {
+		for _, p := range prefixes {
+			if strings.HasPrefix(l, p) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"sort"
+)
+
+// ---------- Weighted scoring ----------
+// A /schedule request with "weights" gets a ranked list instead of the
+// fixed free-slots-then-power heuristic. Every factor is scaled to 0..1
+// (higher is better) and the score is the weighted mean, so weights are
+// relative: {"free_slots": 3, "power_efficiency": 1} means slots count
+// three times as much. Nothing here touches the registry.
+type ScheduleWeights struct {
+	FreeSlots       float64 `json:"free_slots,omitempty"`       // share of the node's slots still free
+	PowerEfficiency float64 `json:"power_efficiency,omitempty"` // low draw relative to the hungriest candidate
+	RAMHeadroom     float64 `json:"ram_headroom,omitempty"`     // RAM above min_ram_gb, relative to the roomiest candidate
+	RegionAffinity  float64 `json:"region_affinity,omitempty"`  // 1 in the requested region, else 0
+}
+
+type ScoreFactors struct {
+	FreeSlots       float64 `json:"free_slots"`
+	PowerEfficiency float64 `json:"power_efficiency"`
+	RAMHeadroom     float64 `json:"ram_headroom"`
+	RegionAffinity  float64 `json:"region_affinity"`
+}
+
+type ScheduleCandidate struct {
+	ScheduleResponse
+	Score   float64      `json:"score"`
+	Factors ScoreFactors `json:"factors"`
+}
+
+const (
+	defaultRankLimit = 10
+	maxRankLimit     = 100
+)
+
+func (w ScheduleWeights) validate() error {
+	for _, v := range []float64{w.FreeSlots, w.PowerEfficiency, w.RAMHeadroom, w.RegionAffinity} {
+		if v < 0 {
+			return errors.New("weights must be >= 0")
+		}
+	}
+	if w.sum() == 0 {
+		return errors.New("at least one weight must be positive")
+	}
+	return nil
+}
+
+func (w ScheduleWeights) sum() float64 {
+	return w.FreeSlots + w.PowerEfficiency + w.RAMHeadroom + w.RegionAffinity
+}
+
+// score is the weighted mean of f; w must have passed validate.
+func (w ScheduleWeights) score(f ScoreFactors) float64 {
+	return (w.FreeSlots*f.FreeSlots +
+		w.PowerEfficiency*f.PowerEfficiency +
+		w.RAMHeadroom*f.RAMHeadroom +
+		w.RegionAffinity*f.RegionAffinity) / w.sum()
+}
+
+// scoreFactors scales n against the candidate maxima. maxPowerW and
+// maxHeadroomGB of 0 mean every candidate ties at 1 on that factor.
+func scoreFactors(n *NodeRecord, q ScheduleRequest, maxPowerW, maxHeadroomGB int) ScoreFactors {
+	var f ScoreFactors
+	if n.Capacity.JobsParallel > 0 {
+		f.FreeSlots = float64(freeSlots(n)) / float64(n.Capacity.JobsParallel)
+	}
+	f.PowerEfficiency = 1
+	if maxPowerW > 0 {
+		f.PowerEfficiency = 1 - float64(n.PowerW)/float64(maxPowerW)
+	}
+	f.RAMHeadroom = 1
+	if maxHeadroomGB > 0 {
+		f.RAMHeadroom = float64(n.RAMGB-q.MinRAMGB) / float64(maxHeadroomGB)
+	}
+	if q.Region != "" && n.Region == q.Region {
+		f.RegionAffinity = 1
+	}
+	return f
+}
+
+// rankCandidates scores nodes (all of which fit q) best first; equal
+// scores fall back to better, so the order is deterministic.
+func rankCandidates(nodes []NodeRecord, q ScheduleRequest, w ScheduleWeights) []ScheduleCandidate {
+	maxPowerW, maxHeadroomGB := 0, 0
+	for i := range nodes {
+		maxPowerW = max(maxPowerW, nodes[i].PowerW)
+		maxHeadroomGB = max(maxHeadroomGB, nodes[i].RAMGB-q.MinRAMGB)
+	}
+
+	type scored struct {
+		n *NodeRecord
+		c ScheduleCandidate
+	}
+	all := make([]scored, len(nodes))
+	for i := range nodes {
+		n := &nodes[i]
+		f := scoreFactors(n, q, maxPowerW, maxHeadroomGB)
+		all[i] = scored{n, ScheduleCandidate{ScheduleResponse: scheduleResponse(n), Score: w.score(f), Factors: f}}
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].c.Score != all[j].c.Score {
+			return all[i].c.Score > all[j].c.Score
+		}
+		return better(all[i].n, all[j].n)
+	})
+	ranked := make([]ScheduleCandidate, len(all))
+	for i, s := range all {
+		ranked[i] = s.c
+	}
+	return ranked
+}
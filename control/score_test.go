@@ -0,0 +1,156 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+func scoreNode(id string, slots, running, powerW, ramGB int, region string) NodeRecord {
+	return NodeRecord{
+		NodeID:      id,
+		Capacity:    Capacity{JobsParallel: slots},
+		JobsRunning: running,
+		PowerW:      powerW,
+		RAMGB:       ramGB,
+		Region:      region,
+	}
+}
+
+func rankedIDs(ranked []ScheduleCandidate) []string {
+	ids := make([]string, len(ranked))
+	for i, c := range ranked {
+		ids[i] = c.NodeID
+	}
+	return ids
+}
+
+func TestScheduleWeightsValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		w    ScheduleWeights
+		ok   bool
+	}{
+		{"all zero", ScheduleWeights{}, false},
+		{"negative", ScheduleWeights{FreeSlots: 1, PowerEfficiency: -1}, false},
+		{"negative cancels to zero", ScheduleWeights{FreeSlots: 1, RegionAffinity: -1}, false},
+		{"one positive", ScheduleWeights{RAMHeadroom: 0.5}, true},
+		{"zeros beside a positive", ScheduleWeights{FreeSlots: 3, PowerEfficiency: 0}, true},
+	} {
+		if err := tc.w.validate(); (err == nil) != tc.ok {
+			t.Errorf("%s: validate = %v, want ok=%v", tc.name, err, tc.ok)
+		}
+	}
+}
+
+func TestScoreFactors(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		n             NodeRecord
+		q             ScheduleRequest
+		maxPowerW     int
+		maxHeadroomGB int
+		want          ScoreFactors
+	}{
+		{
+			name: "zero maxima tie at 1",
+			n:    scoreNode("a", 4, 0, 0, 16, ""),
+			q:    ScheduleRequest{MinRAMGB: 16},
+			want: ScoreFactors{FreeSlots: 1, PowerEfficiency: 1, RAMHeadroom: 1},
+		},
+		{
+			name:          "scaled against the maxima",
+			n:             scoreNode("b", 4, 1, 100, 48, ""),
+			q:             ScheduleRequest{MinRAMGB: 16},
+			maxPowerW:     400,
+			maxHeadroomGB: 64,
+			want:          ScoreFactors{FreeSlots: 0.75, PowerEfficiency: 0.75, RAMHeadroom: 0.5},
+		},
+		{
+			name: "in the requested region",
+			n:    scoreNode("c", 2, 0, 0, 8, "eu-west"),
+			q:    ScheduleRequest{Region: "eu-west"},
+			want: ScoreFactors{FreeSlots: 1, PowerEfficiency: 1, RAMHeadroom: 1, RegionAffinity: 1},
+		},
+		{
+			name: "another region",
+			n:    scoreNode("d", 2, 0, 0, 8, "us-east"),
+			q:    ScheduleRequest{Region: "eu-west"},
+			want: ScoreFactors{FreeSlots: 1, PowerEfficiency: 1, RAMHeadroom: 1},
+		},
+		{
+			name: "no region asked for",
+			n:    scoreNode("e", 2, 0, 0, 8, "eu-west"),
+			want: ScoreFactors{FreeSlots: 1, PowerEfficiency: 1, RAMHeadroom: 1},
+		},
+	} {
+		if got := scoreFactors(&tc.n, tc.q, tc.maxPowerW, tc.maxHeadroomGB); got != tc.want {
+			t.Errorf("%s: got %+v, want %+v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestRankCandidates(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		nodes []NodeRecord
+		q     ScheduleRequest
+		w     ScheduleWeights
+		want  []string
+	}{
+		{
+			// every max is 0: no division by zero, everyone scores 1
+			name:  "zero maxima",
+			nodes: []NodeRecord{scoreNode("b", 2, 0, 0, 8, ""), scoreNode("a", 2, 0, 0, 8, "")},
+			q:     ScheduleRequest{MinRAMGB: 8},
+			w:     ScheduleWeights{PowerEfficiency: 1, RAMHeadroom: 1},
+			want:  []string{"a", "b"},
+		},
+		{
+			name: "region affinity wins",
+			nodes: []NodeRecord{
+				scoreNode("near", 4, 3, 300, 16, "eu-west"),
+				scoreNode("far", 4, 0, 100, 16, "us-east"),
+			},
+			q:    ScheduleRequest{Region: "eu-west"},
+			w:    ScheduleWeights{FreeSlots: 1, RegionAffinity: 5},
+			want: []string{"near", "far"},
+		},
+		{
+			name: "power efficiency",
+			nodes: []NodeRecord{
+				scoreNode("hungry", 2, 0, 400, 16, ""),
+				scoreNode("frugal", 2, 0, 100, 16, ""),
+			},
+			w:    ScheduleWeights{PowerEfficiency: 1},
+			want: []string{"frugal", "hungry"},
+		},
+		{
+			// equal scores fall back to free slots, then power, then node_id
+			name: "ties",
+			nodes: []NodeRecord{
+				scoreNode("c", 4, 0, 100, 16, "eu-west"),
+				scoreNode("b", 2, 0, 100, 16, "eu-west"),
+				scoreNode("a", 2, 0, 50, 16, "eu-west"),
+				scoreNode("d", 2, 0, 50, 16, "eu-west"),
+			},
+			q:    ScheduleRequest{Region: "eu-west"},
+			w:    ScheduleWeights{RegionAffinity: 1},
+			want: []string{"c", "a", "d", "b"},
+		},
+	} {
+		nodes := tc.nodes
+		for range len(nodes) { // every rotation: input order must not leak into the result
+			ranked := rankCandidates(slices.Clone(nodes), tc.q, tc.w)
+			for _, c := range ranked {
+				if math.IsNaN(c.Score) || c.Score < 0 || c.Score > 1 {
+					t.Fatalf("%s: %s scored %v, want 0..1", tc.name, c.NodeID, c.Score)
+				}
+			}
+			if got := rankedIDs(ranked); !slices.Equal(got, tc.want) {
+				t.Fatalf("%s: order %v, want %v", tc.name, got, tc.want)
+			}
+			nodes = slices.Concat(nodes[1:], nodes[:1])
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ---------- Agent version drift ----------
+// semver is MAJOR.MINOR.PATCH with optional -prerelease; +build is ignored.
+type semver struct {
+	major, minor, patch int
+	pre                 []string
+}
+
+func parseSemver(s string) (semver, error) {
+	var v semver
+	s = strings.TrimPrefix(strings.TrimSpace(s), "v")
+	if i := strings.IndexByte(s, '+'); i >= 0 {
+		s = s[:i]
+	}
+	core := s
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		core = s[:i]
+		v.pre = strings.Split(s[i+1:], ".")
+	}
+	parts := strings.Split(core, ".")
+	if len(parts) != 3 {
+		return v, fmt.Errorf("version %q: want MAJOR.MINOR.PATCH", s)
+	}
+	nums := [3]*int{&v.major, &v.minor, &v.patch}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return v, fmt.Errorf("version %q: bad number %q", s, p)
+		}
+		*nums[i] = n
+	}
+	return v, nil
+}
+
+func cmpInt(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// compare follows semver precedence: a release outranks its prereleases,
+// numeric identifiers compare numerically and sort before alphanumeric ones.
+func (a semver) compare(b semver) int {
+	if c := cmpInt(a.major, b.major); c != 0 {
+		return c
+	}
+	if c := cmpInt(a.minor, b.minor); c != 0 {
+		return c
+	}
+	if c := cmpInt(a.patch, b.patch); c != 0 {
+		return c
+	}
+	switch {
+	case len(a.pre) == 0 && len(b.pre) == 0:
+		return 0
+	case len(a.pre) == 0:
+		return 1
+	case len(b.pre) == 0:
+		return -1
+	}
+	for i := 0; i < len(a.pre) && i < len(b.pre); i++ {
+		x, y := a.pre[i], b.pre[i]
+		xn, xerr := strconv.Atoi(x)
+		yn, yerr := strconv.Atoi(y)
+		switch {
+		case xerr == nil && yerr == nil:
+			if c := cmpInt(xn, yn); c != 0 {
+				return c
+			}
+		case xerr == nil:
+			return -1
+		case yerr == nil:
+			return 1
+		default:
+			if c := strings.Compare(x, y); c != 0 {
+				return c
+			}
+		}
+	}
+	return cmpInt(len(a.pre), len(b.pre))
+}
+
+type OutdatedNode struct {
+	NodeID       string `json:"node_id"`
+	Hostname     string `json:"hostname"`
+	AgentVersion string `json:"agent_version"`
+	Unparseable  bool   `json:"unparseable,omitempty"` // version couldn't be read, so assume behind
+}
+
+type OutdatedReport struct {
+	MinVersion string         `json:"min_version"`
+	Count      int            `json:"count"`
+	Nodes      []OutdatedNode `json:"nodes"`
+}
+
+// GET /nodes/outdated?min_version=X.Y.Z
+func outdatedNodesHandler(reg *Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		if !requireKey(w, r) {
+			return
+		}
+		minStr := r.URL.Query().Get("min_version")
+		minVer, err := parseSemver(minStr)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "bad_request", "min_version: "+err.Error())
+			return
+		}
+
+		rep := OutdatedReport{MinVersion: minStr, Nodes: []OutdatedNode{}}
+		for _, n := range reg.List(nodeFilter{}) {
+			v, err := parseSemver(n.AgentVersion)
+			if err == nil && v.compare(minVer) >= 0 {
+				continue
+			}
+			rep.Nodes = append(rep.Nodes, OutdatedNode{
+				NodeID:       n.NodeID,
+				Hostname:     n.Hostname,
+				AgentVersion: n.AgentVersion,
+				Unparseable:  err != nil,
+			})
+		}
+		rep.Count = len(rep.Nodes)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rep)
+	}
+}
@@ -1,16 +1,23 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
-	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 // ---------- Models ----------
@@ -22,6 +29,10 @@ type CPUInfo struct {
 type GPUInfo struct {
 	Name   string `json:"name"`
 	VRAMGB int    `json:"vram_gb"`
+
+	// live usage from the latest heartbeat, see gpu.go
+	UtilPct   *int `json:"util_pct,omitempty"`
+	MemUsedMB *int `json:"mem_used_mb,omitempty"`
 }
 
 type Capacity struct {
@@ -29,43 +40,88 @@ type Capacity struct {
 }
 
 type RegisterRequest struct {
-	Hostname     string    `json:"hostname"`
-	IP           string    `json:"ip"`
-	OS           string    `json:"os"`
-	Arch         string    `json:"arch"`
-	AgentVersion string    `json:"agent_version"`
-	CPU          CPUInfo   `json:"cpu"`
-	GPU          []GPUInfo `json:"gpu"`
-	RAMGB        int       `json:"ram_gb"`
-	UptimeSec    int64     `json:"uptime_sec"`
-	PowerW       int       `json:"power_w"`
-	Capacity     Capacity  `json:"capacity"`
-	Labels       []string  `json:"labels,omitempty"`
+	SchemaVersion int               `json:"schema_version,omitempty"` // see schema.go
+	MachineID     string            `json:"machine_id,omitempty"`     // stable dedup key, preferred over hostname+IP
+	MAC           string            `json:"mac,omitempty"`            // primary NIC address, feeds the hardware fingerprint
+	Hostname      string            `json:"hostname"`
+	IP            string            `json:"ip"`
+	OS            string            `json:"os"`
+	Arch          string            `json:"arch"`
+	AgentVersion  string            `json:"agent_version"`
+	CPU           CPUInfo           `json:"cpu"`
+	GPU           []GPUInfo         `json:"gpu"`
+	RAMGB         int               `json:"ram_gb"`
+	DiskTotalGB   int               `json:"disk_total_gb,omitempty"`
+	DiskFreeGB    int               `json:"disk_free_gb,omitempty"`
+	UptimeSec     int64             `json:"uptime_sec"`
+	PowerW        int               `json:"power_w"`
+	Capacity      Capacity          `json:"capacity"`
+	Labels        []string          `json:"labels,omitempty"`
+	Region        *string           `json:"region,omitempty"` // datacenter/region; must be non-blank when sent
+	Meta          map[string]string `json:"meta,omitempty"`   // free-form agent facts (kernel, instance type, ...)
+	Group         string            `json:"group,omitempty"`  // logical cluster, e.g. "training"
+	CostCenter    string            `json:"cost_center,omitempty"`
+	PricePerHour  float64           `json:"price_per_hour,omitempty"` // charged per online hour, see cost.go
+	Runtime       string            `json:"runtime,omitempty"`        // container runtime, one of knownRuntimes
+	ForceNew      bool              `json:"force_new,omitempty"`
 }
 
 type NodeRecord struct {
-	NodeID       string    `json:"node_id"`
-	Hostname     string    `json:"hostname"`
-	ReportedIP   string    `json:"reported_ip"`
-	PublicIP     string    `json:"public_ip"`
-	OS           string    `json:"os"`
-	Arch         string    `json:"arch"`
-	AgentVersion string    `json:"agent_version"`
-	CPU          CPUInfo   `json:"cpu"`
-	GPU          []GPUInfo `json:"gpu"`
-	RAMGB        int       `json:"ram_gb"`
-	UptimeSec    int64     `json:"uptime_sec"`
-	PowerW       int       `json:"power_w"`
-	Capacity     Capacity  `json:"capacity"`
-	Labels       []string  `json:"labels,omitempty"`
-	LastSeen     time.Time `json:"last_seen"`
-	Status       string    `json:"status"` // online / stale
+	NodeID         string            `json:"node_id"`
+	MachineID      string            `json:"machine_id,omitempty"`
+	MAC            string            `json:"mac,omitempty"`
+	Fingerprint    string            `json:"fingerprint,omitempty"`
+	Hostname       string            `json:"hostname"`
+	ReportedIP     string            `json:"reported_ip"`
+	PublicIP       string            `json:"public_ip"`
+	OS             string            `json:"os"`
+	Arch           string            `json:"arch"`
+	AgentVersion   string            `json:"agent_version"`
+	CPU            CPUInfo           `json:"cpu"`
+	GPU            []GPUInfo         `json:"gpu"`
+	RAMGB          int               `json:"ram_gb"`
+	DiskTotalGB    int               `json:"disk_total_gb,omitempty"`
+	DiskFreeGB     int               `json:"disk_free_gb,omitempty"`
+	UptimeSec      int64             `json:"uptime_sec"`
+	RebootCount    int               `json:"reboot_count"`
+	PowerW         int               `json:"power_w"`
+	Capacity       Capacity          `json:"capacity"`
+	JobsRunning    int               `json:"jobs_running"`
+	Labels         []string          `json:"labels,omitempty"`        // AgentLabels then ServerLabels, deduplicated; what filters see
+	AgentLabels    []string          `json:"agent_labels,omitempty"`  // as last registered
+	ServerLabels   []string          `json:"server_labels,omitempty"` // operator-managed, survive re-registration
+	Region         string            `json:"region,omitempty"`
+	Meta           map[string]string `json:"meta,omitempty"`
+	Group          string            `json:"group,omitempty"`
+	CostCenter     string            `json:"cost_center,omitempty"`
+	PricePerHour   float64           `json:"price_per_hour,omitempty"`
+	Runtime        string            `json:"runtime,omitempty"`
+	RegisteredAt   time.Time         `json:"registered_at"` // most recent (re-)registration
+	LastSeen       time.Time         `json:"last_seen"`
+	LastSeenAgeSec int64             `json:"last_seen_age_sec"`         // computed per read, see Registry.snapshotLocked
+	Status         string            `json:"status"`                    // online / draining / stale / offline
+	StatusSince    time.Time         `json:"status_since"`              // when Status last changed
+	StatusOverride bool              `json:"status_override,omitempty"` // Status was set by hand, see override.go
+	Draining       bool              `json:"draining,omitempty"`
+	Maintenance    bool              `json:"maintenance"`
+	Conflict       bool              `json:"conflict,omitempty"` // another online node reported the same IP
+	Lease          *Lease            `json:"lease,omitempty"`    // active scheduler lease, see lease.go
+
+	tokenHash string        // sha256 of the node token; persisted by state.go only
+	power     *powerRing    // heartbeat power samples; only touched under the registry lock
+	commands  []NodeCommand // queued for the agent, see commands.go
+	lastBeat  time.Time     // last heartbeat that got full processing, for debouncing
+	modified  time.Time     // last change to any served field, for Last-Modified; see touchLocked
+	accruedAt time.Time     // usage is booked up to here, see accrueUsageLocked
 }
 
 type RegisterResponse struct {
 	NodeID               string `json:"node_id"`
+	NodeToken            string `json:"node_token"` // send as X-LEGION-NODE-TOKEN on heartbeats
 	HeartbeatIntervalSec int    `json:"heartbeat_interval_sec"`
+	JitterSec            int    `json:"jitter_sec"` // add to the interval, see Registry.JitterSec
 	Message              string `json:"message"`
+	SchemaVersion        int    `json:"schema_version"` // newest payload version this server accepts
 }
 
 type HeartbeatResponse struct {
@@ -74,68 +130,284 @@ type HeartbeatResponse struct {
 	Message string `json:"message"`
 }
 
+// HeartbeatError tells an agent whether retrying the heartbeat is pointless
+// and it should go back through /register instead.
+type HeartbeatError struct {
+	Error            APIError `json:"error"`
+	NodeID           string   `json:"node_id,omitempty"`
+	ShouldReregister bool     `json:"should_reregister"`
+}
+
 // Agent heartbeat payload (keep it small)
 type AgentHeartbeat struct {
-	NodeID    string `json:"node_id"`
-	UptimeSec int64  `json:"uptime_sec,omitempty"`
-	PowerW    int    `json:"power_w,omitempty"`
+	SchemaVersion int    `json:"schema_version,omitempty"`
+	NodeID        string `json:"node_id"`
+	UptimeSec     int64  `json:"uptime_sec,omitempty"`
+	PowerW        int    `json:"power_w,omitempty"`
+	DiskFreeGB    *int   `json:"disk_free_gb,omitempty"` // 0 is a full disk, not "unchanged"
+
+	Capacity *Capacity  `json:"capacity,omitempty"` // after local reconfiguration
+	GPUs     []GPUUsage `json:"gpus,omitempty"`
+
+	// JobsRunning is the agent's own count; it overrides our reservation
+	// tally so slots leaked by an agent crash come back.
+	JobsRunning *int `json:"jobs_running,omitempty"`
 }
 
 // ---------- Globals ----------
+const (
+	defaultHeartbeatSec      = 30
+	defaultStaleMultiplier   = 2
+	defaultOfflineMultiplier = 10
+	defaultHeartbeatDebounce = time.Second
+)
+
 var (
-	mu                sync.Mutex
-	registry          = map[string]*NodeRecord{}
-	heartbeatInterval = 30 // seconds
-	staleAfter        = 2 * time.Duration(heartbeatInterval) * time.Second
+	shutdownTimeout    = 10 * time.Second
+	registerBodyLimit  = int64(64 << 10)
+	heartbeatBodyLimit = int64(4 << 10)
+	batchBodyLimit     = int64(1 << 20)
+	maxBatchSize       = 256
+	ready              atomic.Bool // flipped at the end of main() setup, cleared on shutdown
 )
 
 // ---------- Helpers ----------
-func randomID(n int) string {
+// randomID returns n random bytes as hex. There is deliberately no
+// fallback: a guessable ID or token is worse than a failed request.
+func randomID(n int) (string, error) {
 	b := make([]byte, n)
 	if _, err := rand.Read(b); err != nil {
-		return fmt.Sprintf("ts%x", time.Now().UnixNano())
+		return "", fmt.Errorf("random id: %w", err)
 	}
-	return hex.EncodeToString(b)
+	return hex.EncodeToString(b), nil
 }
 
+// trustedProxies (LEGION_TRUSTED_PROXIES) are the only peers whose
+// X-Forwarded-For we believe. Empty means XFF is ignored entirely.
+var trustedProxies []*net.IPNet
+
+func isTrustedProxy(ip net.IP) bool {
+	for _, n := range trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// getPublicIP returns the socket peer unless it is a trusted proxy, in which
+// case it walks X-Forwarded-For right to left and returns the first hop that
+// isn't one of ours (anything further left is client-controlled).
+// Everything returned is canonical (ip.String()), so "::1" and
+// "0:0:0:0:0:0:0:1" or "::ffff:10.0.0.1" and "10.0.0.1" compare equal.
 func getPublicIP(r *http.Request) string {
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		parts := strings.Split(xff, ",")
-		return strings.TrimSpace(parts[0])
+	peer := parseHostIP(r.RemoteAddr)
+	if peer == nil {
+		return r.RemoteAddr // not an IP socket (tests, unix sockets); keep as-is
 	}
-	host, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		return r.RemoteAddr
+	if !isTrustedProxy(peer) {
+		return peer.String()
+	}
+
+	var hops []string
+	for _, h := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(h, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := parseHostIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			break // garbage in the chain; don't trust anything past it
+		}
+		if !isTrustedProxy(ip) {
+			return ip.String()
+		}
+	}
+	return peer.String()
+}
+
+// parseHostIP accepts "ip", "[ip]", "ip:port" and "[ip]:port", as seen in
+// RemoteAddr and in the wild in X-Forwarded-For. IPv6 zones are dropped.
+func parseHostIP(s string) net.IP {
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	if i := strings.IndexByte(s, '%'); i >= 0 {
+		s = s[:i]
+	}
+	return net.ParseIP(s)
+}
+
+// canonicalIP normalizes s if it is an IP and leaves it alone otherwise.
+func canonicalIP(s string) string {
+	if ip := net.ParseIP(s); ip != nil {
+		return ip.String()
 	}
-	return host
+	return s
+}
+
+// methodNotAllowed answers 405 with the Allow header RFC 9110 requires.
+func methodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "method not allowed")
+}
+
+// decodeJSON reads at most limit bytes of JSON into v, answering 413 or
+// 400 itself on failure.
+func decodeJSON(w http.ResponseWriter, r *http.Request, limit int64, v any) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, limit)
+	err := json.NewDecoder(r.Body).Decode(v)
+	if err == nil {
+		return true
+	}
+	var tooBig *http.MaxBytesError
+	if errors.As(err, &tooBig) {
+		writeError(w, http.StatusRequestEntityTooLarge, "body_too_large", fmt.Sprintf("request body too large (limit %d bytes)", tooBig.Limit))
+		return false
+	}
+	writeError(w, http.StatusBadRequest, "bad_json", "bad json: "+err.Error())
+	return false
 }
 
 func requireKey(w http.ResponseWriter, r *http.Request) bool {
-	want := os.Getenv("LEGION_KEY")
+	want := apiKey
 	got := r.Header.Get("X-LEGION-KEY")
 	if want == "" {
-		return true // dev mode
+		w.Header().Set("X-Legion-Insecure", "true") // dev mode, let monitoring notice
+		return true
 	}
 	if got == "" || got != want {
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		writeError(w, http.StatusUnauthorized, "unauthorized", "unauthorized")
 		return false
 	}
 	return true
 }
 
 // ---------- Handlers ----------
-func heartbeatHandler(w http.ResponseWriter, r *http.Request) {
+func heartbeatHandler(reg *Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(HeartbeatResponse{
+			Status:  "ok",
+			Time:    reg.Now().Format(time.RFC3339),
+			Message: "9th Legion Control Node active",
+		})
+	}
+}
+
+// readiness probe: 503 until state is loaded and the monitor is running
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(HeartbeatResponse{
-		Status:  "ok",
-		Time:    time.Now().Format(time.RFC3339),
-		Message: "9th Legion Control Node active",
-	})
+	if !ready.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]string{"status": "not ready"})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"status": "ready"})
+}
+
+func registerHandler(reg *Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+		registerRequestsTotal.Inc()
+		if !requireKey(w, r) {
+			return
+		}
+
+		var req RegisterRequest
+		if !decodeJSON(w, r, registerBodyLimit, &req) {
+			return
+		}
+
+		resp, err := registerOne(reg, req, getPublicIP(r))
+		var ve *ValidationError
+		switch {
+		case errors.As(err, &ve):
+			writeErrorFor(w, http.StatusBadRequest, err)
+			return
+		case errors.Is(err, errRegistryFull):
+			writeErrorFor(w, http.StatusServiceUnavailable, err)
+			return
+		case errors.Is(err, errHostnameTaken):
+			writeErrorFor(w, http.StatusConflict, err)
+			return
+		case errors.Is(err, errQuarantined):
+			writeErrorFor(w, http.StatusForbidden, err)
+			return
+		case err != nil:
+			log.Printf("register failed: %v", err)
+			writeError(w, http.StatusInternalServerError, "internal", "internal error")
+			return
+		}
+		logNodeID(r, resp.NodeID)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// prepareRegister validates req and returns it in the form Register stores.
+// Shared by real registration and the /register/validate dry run.
+func prepareRegister(req RegisterRequest, publicIP string) (RegisterRequest, error) {
+	if err := req.upgrade(); err != nil {
+		return req, &ValidationError{Fields: []string{err.Error()}}
+	}
+	if err := req.Validate(); err != nil {
+		return req, err
+	}
+	ip, err := normalizeReportedIP(req.IP, publicIP)
+	if err != nil {
+		return req, &ValidationError{Fields: []string{err.Error()}}
+	}
+	req.IP = ip
+	req.MAC = strings.TrimSpace(req.MAC)
+	req.Group = strings.TrimSpace(req.Group)
+	req.Runtime = registerRuntime(req.Runtime)
+	req.Labels = normalizeLabels(req.Labels)
+	if req.Region != nil {
+		region := strings.TrimSpace(*req.Region)
+		req.Region = &region
+	}
+	return req, nil
+}
+
+// registerOne is the shared validate-then-register path for single and batch registration.
+func registerOne(reg *Registry, req RegisterRequest, publicIP string) (RegisterResponse, error) {
+	req, err := prepareRegister(req, publicIP)
+	if err != nil {
+		return RegisterResponse{}, err
+	}
+
+	node, token, err := reg.Register(req, publicIP)
+	if err != nil {
+		return RegisterResponse{}, err
+	}
+	statRegistrations.Add(1)
+	return RegisterResponse{
+		NodeID:               node.NodeID,
+		NodeToken:            token,
+		HeartbeatIntervalSec: reg.HeartbeatInterval(),
+		JitterSec:            reg.JitterSec(node.NodeID),
+		Message:              "registered",
+		SchemaVersion:        currentSchemaVersion,
+	}, nil
 }
 
-func registerHandler(w http.ResponseWriter, r *http.Request) {
+// ValidateResponse echoes what /register would store, without storing it.
+type ValidateResponse struct {
+	Valid       bool            `json:"valid"`
+	PublicIP    string          `json:"public_ip"`
+	Fingerprint string          `json:"fingerprint,omitempty"`
+	Request     RegisterRequest `json:"request"`
+}
+
+// POST /register/validate — dry run for agent CI; never touches the registry.
+func registerValidateHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		methodNotAllowed(w, http.MethodPost)
 		return
 	}
 	if !requireKey(w, r) {
@@ -143,141 +415,481 @@ func registerHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req RegisterRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "bad json: "+err.Error(), http.StatusBadRequest)
+	if !decodeJSON(w, r, registerBodyLimit, &req) {
 		return
 	}
 
 	publicIP := getPublicIP(r)
-
-	mu.Lock()
-	defer mu.Unlock()
-
-	// Idempotent: match existing by hostname + reported IP
-	var node *NodeRecord
-	for _, n := range registry {
-		if n.Hostname == req.Hostname && n.ReportedIP == req.IP {
-			node = n
-			break
-		}
-	}
-	if node == nil {
-		node = &NodeRecord{NodeID: randomID(8)}
-		registry[node.NodeID] = node
-	}
-
-	node.Hostname = req.Hostname
-	node.ReportedIP = req.IP
-	node.PublicIP = publicIP
-	node.OS = req.OS
-	node.Arch = req.Arch
-	node.AgentVersion = req.AgentVersion
-	node.CPU = req.CPU
-	node.GPU = req.GPU
-	node.RAMGB = req.RAMGB
-	node.UptimeSec = req.UptimeSec
-	node.PowerW = req.PowerW
-	node.Capacity = req.Capacity
-	node.Labels = req.Labels
-	node.LastSeen = time.Now().UTC()
-	node.Status = "online"
+	req, err := prepareRegister(req, publicIP)
+	if err != nil {
+		writeErrorFor(w, http.StatusBadRequest, err)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(RegisterResponse{
-		NodeID:               node.NodeID,
-		HeartbeatIntervalSec: heartbeatInterval,
-		Message:              "registered",
+	json.NewEncoder(w).Encode(ValidateResponse{
+		Valid:       true,
+		PublicIP:    publicIP,
+		Fingerprint: req.Fingerprint(),
+		Request:     req,
 	})
 }
 
-func listNodesHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
-		return
+// BatchRegisterItem is one entry of a /register/batch reply; exactly one
+// of the embedded response or Error is set.
+type BatchRegisterItem struct {
+	*RegisterResponse
+	Error *APIError `json:"error,omitempty"`
+}
+
+// POST /register/batch — a gateway agent registering many machines at once.
+// Items succeed or fail independently; replies keep request order.
+func registerBatchHandler(reg *Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+		registerRequestsTotal.Inc()
+		if !requireKey(w, r) {
+			return
+		}
+
+		var reqs []RegisterRequest
+		if !decodeJSON(w, r, batchBodyLimit, &reqs) {
+			return
+		}
+		if len(reqs) > maxBatchSize {
+			writeError(w, http.StatusRequestEntityTooLarge, "body_too_large", fmt.Sprintf("batch too large (max %d items)", maxBatchSize))
+			return
+		}
+
+		publicIP := getPublicIP(r)
+		out := make([]BatchRegisterItem, len(reqs))
+		for i, req := range reqs {
+			resp, err := registerOne(reg, req, publicIP)
+			if err != nil {
+				e := apiError(err)
+				out[i].Error = &e
+				continue
+			}
+			out[i].RegisterResponse = &resp
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(out)
+	}
+}
+
+func listNodesHandler(reg *Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		scope, ok := requireListKey(w, r)
+		if !ok {
+			return
+		}
+		q := r.URL.Query()
+		page, paged, err := parsePage(q)
+		if err != nil {
+			writeErrorFor(w, http.StatusBadRequest, err)
+			return
+		}
+
+		filter, err := parseNodeFilter(q)
+		if err != nil {
+			writeErrorFor(w, http.StatusBadRequest, err)
+			return
+		}
+		filter.LabelPrefixes = scope
+		nodes := reg.List(filter)
+		filter.sort(nodes)
+		if notModified(w, r, listETag(nodes, r.URL.RequestURI())) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		if wantsCSV(r) {
+			if paged {
+				nodes = page.page(nodes).Nodes
+			}
+			writeNodesCSV(w, nodes)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if paged {
+			json.NewEncoder(w).Encode(page.page(nodes))
+			return
+		}
+		json.NewEncoder(w).Encode(nodes)
 	}
+}
+
+func agentHeartbeatHandler(reg *Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+		heartbeatsTotal.Inc()
+
+		var hb AgentHeartbeat
+		if !decodeJSON(w, r, heartbeatBodyLimit, &hb) {
+			return
+		}
+		if hb.NodeID == "" {
+			writeHeartbeatError(w, http.StatusBadRequest, HeartbeatError{Error: APIError{Code: "bad_request", Message: "node_id required"}, ShouldReregister: true})
+			return
+		}
+		if err := hb.upgrade(); err != nil {
+			writeHeartbeatError(w, http.StatusBadRequest, HeartbeatError{Error: apiError(err), NodeID: hb.NodeID})
+			return
+		}
+		logNodeID(r, hb.NodeID)
+		if !nodeTokenOK(reg, r, hb.NodeID) {
+			// a fresh registration issues a new token
+			writeHeartbeatError(w, http.StatusUnauthorized, HeartbeatError{Error: APIError{Code: "unauthorized", Message: "unauthorized"}, NodeID: hb.NodeID, ShouldReregister: true})
+			return
+		}
+
+		if _, ok := reg.Heartbeat(hb); !ok {
+			statUnknownNodeRejects.Add(1)
+			// most likely the server restarted without state
+			writeHeartbeatError(w, http.StatusNotFound, HeartbeatError{Error: APIError{Code: "unknown_node", Message: "unknown node_id"}, NodeID: hb.NodeID, ShouldReregister: true})
+			return
+		}
+
+		statHeartbeats.Add(1)
+
+		resp := map[string]any{
+			"status":                 "ok",
+			"next_heartbeat_seconds": reg.HeartbeatInterval(),
+			"jitter_sec":             reg.JitterSec(hb.NodeID),
+			"server_time":            reg.Now().Format(time.RFC3339),
+		}
+		if cmds := reg.DeliverCommands(hb.NodeID); len(cmds) > 0 {
+			resp["commands"] = cmds
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}
+}
+
+func writeHeartbeatError(w http.ResponseWriter, status int, e HeartbeatError) {
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(e)
+}
+
+// POST /agent/deregister {"node_id"} — clean agent shutdown; the record
+// goes away now instead of aging through stale/offline.
+func agentDeregisterHandler(reg *Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+
+		var body struct {
+			NodeID string `json:"node_id"`
+		}
+		if !decodeJSON(w, r, heartbeatBodyLimit, &body) {
+			return
+		}
+		if body.NodeID == "" {
+			writeHeartbeatError(w, http.StatusBadRequest, HeartbeatError{Error: APIError{Code: "bad_request", Message: "node_id required"}})
+			return
+		}
+		logNodeID(r, body.NodeID)
+		if !nodeTokenOK(reg, r, body.NodeID) {
+			writeHeartbeatError(w, http.StatusUnauthorized, HeartbeatError{Error: APIError{Code: "unauthorized", Message: "unauthorized"}, NodeID: body.NodeID})
+			return
+		}
 
-	mu.Lock()
-	defer mu.Unlock()
+		if !reg.Deregister(body.NodeID) {
+			writeHeartbeatError(w, http.StatusNotFound, HeartbeatError{Error: APIError{Code: "unknown_node", Message: "unknown node_id"}, NodeID: body.NodeID})
+			return
+		}
 
-	out := make([]NodeRecord, 0, len(registry))
-	for _, n := range registry {
-		out = append(out, *n)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"status":  "deregistered",
+			"node_id": body.NodeID,
+		})
 	}
-	json.NewEncoder(w).Encode(out)
 }
 
-func agentHeartbeatHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
-		return
+// /nodes/{id} — GET returns one node, DELETE deregisters it
+func nodeHandler(reg *Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		logNodeID(r, r.PathValue("id"))
+		switch r.Method {
+		case http.MethodGet:
+			getNodeHandler(reg, w, r)
+		case http.MethodDelete:
+			deleteNodeHandler(reg, w, r)
+		default:
+			methodNotAllowed(w, http.MethodGet, http.MethodDelete)
+		}
 	}
+}
+
+func getNodeHandler(reg *Registry, w http.ResponseWriter, r *http.Request) {
 	if !requireKey(w, r) {
 		return
 	}
+	node, ok := reg.Get(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "unknown_node", "unknown node_id")
+		return
+	}
+	if notModifiedSince(w, r, node.modified) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(node)
+}
 
-	var hb AgentHeartbeat
-	if err := json.NewDecoder(r.Body).Decode(&hb); err != nil {
-		http.Error(w, "bad json: "+err.Error(), http.StatusBadRequest)
+func deleteNodeHandler(reg *Registry, w http.ResponseWriter, r *http.Request) {
+	if !requireKey(w, r) {
 		return
 	}
-	if hb.NodeID == "" {
-		http.Error(w, "node_id required", http.StatusBadRequest)
+	if !reg.Delete(r.PathValue("id")) {
+		writeError(w, http.StatusNotFound, "unknown_node", "unknown node_id")
 		return
 	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// POST /nodes/{id}/reserve and /nodes/{id}/release
+func reservationHandler(reg *Registry, reserve bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+		if !requireKey(w, r) {
+			return
+		}
+		id := r.PathValue("id")
+		logNodeID(r, id)
+		key := r.Header.Get(idemHeader)
+		if len(key) > maxIdemKey {
+			writeError(w, http.StatusBadRequest, "bad_request", fmt.Sprintf("%s longer than %d bytes", idemHeader, maxIdemKey))
+			return
+		}
 
-	mu.Lock()
-	defer mu.Unlock()
+		var node NodeRecord
+		var err error
+		if reserve {
+			node, err = reg.Reserve(id, key, r.Header.Get(leaseTokenHeader))
+		} else {
+			node, err = reg.Release(id, key)
+		}
+		switch {
+		case errors.Is(err, errUnknownNode):
+			writeErrorFor(w, http.StatusNotFound, err)
+			return
+		case errors.Is(err, errLeased):
+			writeErrorFor(w, http.StatusConflict, err)
+			return
+		case errors.Is(err, errIdemKeyReused):
+			writeErrorFor(w, http.StatusUnprocessableEntity, err)
+			return
+		case err != nil:
+			writeErrorFor(w, http.StatusConflict, err)
+			return
+		}
 
-	node, ok := registry[hb.NodeID]
-	if !ok {
-		http.Error(w, "unknown node_id", http.StatusNotFound)
-		return
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"node_id":       node.NodeID,
+			"jobs_running":  node.JobsRunning,
+			"jobs_parallel": node.Capacity.JobsParallel,
+		})
 	}
+}
+
+// newMux wires every route to reg, without the logging/CORS middleware
+// main adds, so tests can drive it with httptest instead of a real port.
+// Key, scope and proxy settings still come from the package-level config.
+func newMux(reg *Registry, perMin, burst int) *http.ServeMux {
+	registerLimiter := newRateLimiter(reg.clock, perMin, burst)
+	heartbeatLimiter := newRateLimiter(reg.clock, perMin, burst)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/heartbeat", heartbeatHandler(reg))
+	mux.HandleFunc("/healthz", healthzHandler)
+	mux.HandleFunc("/register", withRateLimit(registerLimiter, registerHandler(reg)))                 // POST
+	mux.HandleFunc("/register/batch", withRateLimit(registerLimiter, registerBatchHandler(reg)))      // POST
+	mux.HandleFunc("/register/validate", withRateLimit(registerLimiter, registerValidateHandler))     // POST, dry run
+	mux.HandleFunc("/nodes", listNodesHandler(reg))                                                   // GET
+	mux.HandleFunc("/nodes.csv", listNodesHandler(reg))                                               // GET, CSV export
+	mux.HandleFunc("/nodes/{id}", nodeHandler(reg))                                                   // GET, DELETE
+	mux.HandleFunc("/nodes/{id}/reserve", reservationHandler(reg, true))                              // POST
+	mux.HandleFunc("/nodes/{id}/release", reservationHandler(reg, false))                             // POST
+	mux.HandleFunc("/agent/heartbeat", withRateLimit(heartbeatLimiter, agentHeartbeatHandler(reg)))   // POST
+	mux.HandleFunc("/schedule", scheduleHandler(reg))                                                 // POST
+	mux.HandleFunc("/nodes/stream", streamNodesHandler(reg))                                          // GET (WebSocket)
+	mux.HandleFunc("/nodes/{id}/power/history", powerHistoryHandler(reg))                             // GET
+	mux.HandleFunc("/capacity", capacityHandler(reg))                                                 // GET
+	mux.HandleFunc("/nodes/{id}/labels", patchLabelsHandler(reg))                                     // PATCH
+	mux.HandleFunc("/events", eventsHandler(reg))                                                     // GET
+	mux.HandleFunc("/nodes/outdated", outdatedNodesHandler(reg))                                      // GET
+	mux.HandleFunc("/nodes/{id}/maintenance", maintenanceHandler(reg))                                // POST
+	mux.HandleFunc("/nodes/summary", summaryHandler(reg))                                             // GET
+	mux.HandleFunc("/nodes/{id}/drain", drainHandler(reg))                                            // POST
+	mux.HandleFunc("/stats", statsHandler(reg))                                                       // GET
+	mux.HandleFunc("/groups", groupsHandler(reg))                                                     // GET
+	mux.HandleFunc("/nodes/{id}/command", enqueueCommandHandler(reg))                                 // POST
+	mux.HandleFunc("/agent/command/ack", withRateLimit(heartbeatLimiter, ackCommandHandler(reg)))     // POST
+	mux.HandleFunc("/agent/deregister", withRateLimit(heartbeatLimiter, agentDeregisterHandler(reg))) // POST
+	mux.HandleFunc("/{$}", statusPageHandler(reg))                                                    // GET, HTML
+	mux.HandleFunc("/nodes/{id}/lease", leaseHandler(reg))                                            // POST, DELETE
+	mux.HandleFunc("/report/cost", costReportHandler(reg))                                            // GET
+	mux.HandleFunc("/nodes/{id}/status", statusOverrideHandler(reg))                                  // POST
+	mux.HandleFunc("/version", versionHandler(reg))                                                   // GET
+	mux.HandleFunc("/nodes/prune", pruneHandler(reg))                                                 // POST
+	mux.HandleFunc("/nodes/top", topNodesHandler(reg))                                                // GET
+	mux.HandleFunc("/admin/restart", adminRestartHandler)                                             // POST, only with LEGION_ADMIN_RESTART
+	mux.HandleFunc("/gpus", gpuInventoryHandler(reg))                                                 // GET
+	mux.HandleFunc("/quarantine", quarantineHandler(reg))                                             // GET, POST
+	mux.HandleFunc("/quarantine/{id}", unquarantineHandler(reg))                                      // DELETE
+	mux.Handle("/metrics", promhttp.Handler())                                                        // GET, aggregates only so left open for scrapers
+	return mux
+}
 
-	// Optional live updates
-	if hb.UptimeSec > 0 {
-		node.UptimeSec = hb.UptimeSec
+func main() {
+	cfg, err := Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	reg := NewRegistry(realClock{})
+	cfg.apply(reg)
+	if err := loadHistoryConfig(reg); err != nil {
+		log.Fatal(err)
 	}
-	if hb.PowerW > 0 {
-		node.PowerW = hb.PowerW
+	if err := loadPowerBudgetConfig(reg); err != nil {
+		log.Fatal(err)
 	}
-	node.LastSeen = time.Now().UTC()
-	node.Status = "online"
+	if err := loadRegistryLimitConfig(reg); err != nil {
+		log.Fatal(err)
+	}
+	if err := loadLeaseConfig(reg); err != nil {
+		log.Fatal(err)
+	}
+	if err := loadBodyLimitConfig(); err != nil {
+		log.Fatal(err)
+	}
+	if err := loadTrustedProxies(); err != nil {
+		log.Fatal(err)
+	}
+	if err := loadKeyScopes(); err != nil {
+		log.Fatal(err)
+	}
+	registerMetrics(reg)
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]any{
-		"status":                 "ok",
-		"next_heartbeat_seconds": heartbeatInterval,
-		"server_time":            time.Now().Format(time.RFC3339),
-	})
-}
+	perMin, burst, err := loadRateLimitConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	mux := newMux(reg, perMin, burst)
+
+	done := make(chan struct{})
+
+	auditFile, err := loadAuditFileConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if auditFile != nil {
+		reg.auditFile = auditFile
+		go auditFile.run(done)
+	}
+
+	stateFile := cfg.StateFile
+	if stateFile != "" {
+		if err := loadState(reg, stateFile); err != nil {
+			log.Fatalf("load state %s: %v", stateFile, err)
+		}
+		startStateSaver(reg, stateFile, done)
+	}
 
-// background: mark nodes stale if they stop pinging
-func startStaleMonitor() {
-	ticker := time.NewTicker(15 * time.Second)
+	simNodes, err := loadSimulateConfig(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if simNodes > 0 {
+		startSimulation(reg, simNodes, done)
+	}
+
+	if hook := loadNodeHookConfig(); hook != nil {
+		reg.nodeHook = hook
+		go hook.run(done)
+	}
+
+	alerts, err := loadAlertConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if alerts != nil {
+		go alerts.run(done)
+	}
+	monitorEvery, err := loadMonitorInterval()
+	if err != nil {
+		log.Fatal(err)
+	}
+	startStaleMonitor(reg, done, monitorEvery, alerts)
+
+	addr, certFile, keyFile := cfg.ListenAddr, cfg.TLSCert, cfg.TLSKey
+
+	srv := &http.Server{
+		Addr: addr,
+		Handler: withRequestID(withRequestLog(newRequestLogger(os.Stderr),
+			withCORS(parseOrigins(os.Getenv("LEGION_CORS_ORIGINS")), mux))),
+	}
+	srv.RegisterOnShutdown(reg.hub.closeAll) // hijacked stream conns aren't tracked by Shutdown
 	go func() {
-		for range ticker.C {
-			now := time.Now().UTC()
-			mu.Lock()
-			for _, n := range registry {
-				if now.Sub(n.LastSeen) > staleAfter {
-					n.Status = "stale"
-				}
-			}
-			mu.Unlock()
+		var err error
+		if certFile != "" {
+			fmt.Printf("Legion Control %s listening on %s (TLS)...\n", version, addr)
+			err = srv.ListenAndServeTLS(certFile, keyFile)
+		} else {
+			fmt.Printf("Legion Control %s listening on %s...\n", version, addr)
+			err = srv.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("listen: %v", err)
 		}
 	}()
-}
 
-func main() {
-	http.HandleFunc("/heartbeat", heartbeatHandler)
-	http.HandleFunc("/register", registerHandler)              // POST
-	http.HandleFunc("/nodes", listNodesHandler)                // GET
-	http.HandleFunc("/agent/heartbeat", agentHeartbeatHandler) // POST
+	ready.Store(true)
 
-	startStaleMonitor()
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case <-sig:
+	case <-restartRequests:
+	}
+	fmt.Println("Legion Control shutting down...")
+	ready.Store(false)
+
+	// let in-flight registrations and heartbeats finish
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("shutdown: %v", err)
+	}
+	close(done)
 
-	fmt.Println("Legion Control listening on port 8081...")
-	http.ListenAndServe(":8081", nil)
+	if stateFile != "" {
+		if err := saveState(reg, stateFile); err != nil {
+			log.Printf("state save failed: %v", err)
+		}
+	}
+	if auditFile != nil {
+		<-auditFile.stopped // flush queued audit lines
+	}
 }
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// ---------- Helpers ----------
+var testEpoch = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// newTestServer wires a registry on a fake clock into the real mux. Rate
+// limits are set high enough that tests never trip them.
+func newTestServer(t *testing.T) (*Registry, *fakeClock, http.Handler) {
+	t.Helper()
+	clock := newFakeClock(testEpoch)
+	reg := NewRegistry(clock)
+	return reg, clock, newMux(reg, 1_000_000, 1_000_000)
+}
+
+// withAPIKey sets the shared LEGION_KEY for one test.
+func withAPIKey(t *testing.T, key string) {
+	t.Helper()
+	prev := apiKey
+	apiKey = key
+	t.Cleanup(func() { apiKey = prev })
+}
+
+func doRequest(t *testing.T, h http.Handler, method, path string, body any, header http.Header) *httptest.ResponseRecorder {
+	t.Helper()
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatalf("encode body: %v", err)
+		}
+	}
+	req := httptest.NewRequest(method, path, &buf)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func testRegisterRequest(hostname, ip string) RegisterRequest {
+	return RegisterRequest{
+		Hostname:     hostname,
+		IP:           ip,
+		OS:           "linux",
+		Arch:         "amd64",
+		AgentVersion: "1.0.0",
+		CPU:          CPUInfo{Model: "test", Cores: 8},
+		RAMGB:        32,
+		Capacity:     Capacity{JobsParallel: 2},
+	}
+}
+
+func registerNode(t *testing.T, h http.Handler, req RegisterRequest) RegisterResponse {
+	t.Helper()
+	rec := doRequest(t, h, http.MethodPost, "/register", req, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("register %s: status %d: %s", req.Hostname, rec.Code, rec.Body)
+	}
+	var resp RegisterResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode register response: %v", err)
+	}
+	return resp
+}
+
+func listNodes(t *testing.T, h http.Handler) []NodeRecord {
+	t.Helper()
+	rec := doRequest(t, h, http.MethodGet, "/nodes", nil, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("list nodes: status %d: %s", rec.Code, rec.Body)
+	}
+	var nodes []NodeRecord
+	if err := json.NewDecoder(rec.Body).Decode(&nodes); err != nil {
+		t.Fatalf("decode nodes: %v", err)
+	}
+	return nodes
+}
+
+func decodeErrorCode(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	var env errorEnvelope
+	if err := json.NewDecoder(rec.Body).Decode(&env); err != nil {
+		t.Fatalf("decode error body: %v", err)
+	}
+	return env.Error.Code
+}
+
+// ---------- Tests ----------
+func TestDeleteNodeRemovesFromList(t *testing.T) {
+	_, _, h := newTestServer(t)
+	keep := registerNode(t, h, testRegisterRequest("keep", "10.0.0.1"))
+	gone := registerNode(t, h, testRegisterRequest("gone", "10.0.0.2"))
+
+	rec := doRequest(t, h, http.MethodDelete, "/nodes/"+gone.NodeID, nil, nil)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("delete: status %d: %s", rec.Code, rec.Body)
+	}
+
+	nodes := listNodes(t, h)
+	if len(nodes) != 1 || nodes[0].NodeID != keep.NodeID {
+		t.Fatalf("after delete got %+v, want only %s", nodes, keep.NodeID)
+	}
+	if rec := doRequest(t, h, http.MethodGet, "/nodes/"+gone.NodeID, nil, nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("get deleted node: status %d, want 404", rec.Code)
+	}
+	if rec := doRequest(t, h, http.MethodDelete, "/nodes/"+gone.NodeID, nil, nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("second delete: status %d, want 404", rec.Code)
+	}
+}
+
+func TestReadEndpointsRequireKey(t *testing.T) {
+	_, _, h := newTestServer(t)
+	id := registerNode(t, h, testRegisterRequest("secret", "10.0.0.1")).NodeID
+	withAPIKey(t, "s3cret")
+
+	for _, path := range []string{"/nodes", "/nodes/" + id} {
+		for name, header := range map[string]http.Header{
+			"missing": nil,
+			"wrong":   {"X-Legion-Key": {"nope"}},
+		} {
+			if rec := doRequest(t, h, http.MethodGet, path, nil, header); rec.Code != http.StatusUnauthorized {
+				t.Errorf("GET %s with %s key: status %d, want 401", path, name, rec.Code)
+			}
+		}
+		ok := http.Header{"X-Legion-Key": {"s3cret"}}
+		if rec := doRequest(t, h, http.MethodGet, path, nil, ok); rec.Code != http.StatusOK {
+			t.Errorf("GET %s with right key: status %d, want 200", path, rec.Code)
+		}
+	}
+}
+
+func TestRegisterDedupsEquivalentIPs(t *testing.T) {
+	for _, tc := range []struct{ first, second, want string }{
+		{"2001:DB8:0:0::0001", "[2001:db8::1]", "2001:db8::1"},
+		{"::ffff:10.0.0.5", "10.0.0.5", "10.0.0.5"},
+	} {
+		_, _, h := newTestServer(t)
+		a := registerNode(t, h, testRegisterRequest("v6", tc.first)).NodeID
+		b := registerNode(t, h, testRegisterRequest("v6", tc.second)).NodeID
+		if a != b {
+			t.Errorf("%s and %s registered as two nodes", tc.first, tc.second)
+			continue
+		}
+		nodes := listNodes(t, h)
+		if len(nodes) != 1 || nodes[0].ReportedIP != tc.want {
+			t.Errorf("%s/%s: got %+v, want one node with ip %s", tc.first, tc.second, nodes, tc.want)
+		}
+	}
+}
+
+func TestStatsUptimeFollowsClock(t *testing.T) {
+	_, clock, h := newTestServer(t)
+	clock.Advance(90 * time.Second)
+	rec := doRequest(t, h, http.MethodGet, "/stats", nil, nil)
+	var st ServerStats
+	if err := json.NewDecoder(rec.Body).Decode(&st); err != nil {
+		t.Fatalf("decode stats: %v", err)
+	}
+	if !st.StartTime.Equal(testEpoch) || st.UptimeSec != 90 {
+		t.Fatalf("start %s uptime %d, want %s and 90", st.StartTime, st.UptimeSec, testEpoch)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"time"
+)
+
+// ---------- Simulated nodes ----------
+// LEGION_SIMULATE_NODES=N registers N fake machines at startup and keeps
+// them heartbeating, all through registerOne and Registry.Heartbeat, for
+// load-testing dashboards and the monitor. Off by default. It refuses to
+// run alongside LEGION_KEY or LEGION_STATE_FILE, so fake nodes can't
+// leak into a real deployment or its saved state. Simulated nodes are
+// tagged group "simulated" and meta simulated=true.
+const maxSimulatedNodes = 10000
+
+var (
+	simGPUs  = []GPUInfo{{Name: "NVIDIA RTX 3090", VRAMGB: 24}, {Name: "NVIDIA RTX 4090", VRAMGB: 24}, {Name: "NVIDIA A100", VRAMGB: 80}}
+	simRAMGB = []int{8, 16, 32, 64, 128}
+	simRegs  = []string{"us-east", "us-west", "eu-central"}
+)
+
+type simNode struct {
+	id          string
+	basePowerW  int
+	jobs        int
+	jobsMax     int
+	gpus        int
+	uptimeStart time.Time
+	gone        bool // deleted or pruned; stop heartbeating it
+}
+
+// loadSimulateConfig returns 0 when simulation is off.
+func loadSimulateConfig(cfg Config) (int, error) {
+	n, err := envPositiveInt("LEGION_SIMULATE_NODES", 0)
+	if err != nil || n == 0 {
+		return 0, err
+	}
+	if n > maxSimulatedNodes {
+		return 0, fmt.Errorf("LEGION_SIMULATE_NODES must be at most %d", maxSimulatedNodes)
+	}
+	if cfg.Key != "" || cfg.StateFile != "" {
+		return 0, fmt.Errorf("LEGION_SIMULATE_NODES only runs without a key and state file")
+	}
+	return n, nil
+}
+
+// startSimulation registers n fake nodes and heartbeats them round-robin,
+// spread evenly over one heartbeat interval.
+func startSimulation(reg *Registry, n int, done <-chan struct{}) {
+	log.Printf("WARNING: simulating %d fake nodes (LEGION_SIMULATE_NODES); never use this in production", n)
+	nodes := make([]*simNode, 0, n)
+	for i := range n {
+		sn, err := registerSimNode(reg, i)
+		if err != nil {
+			log.Printf("simulate: node %d: %v", i, err)
+			continue
+		}
+		nodes = append(nodes, sn)
+	}
+	if len(nodes) == 0 {
+		return
+	}
+
+	every := time.Duration(reg.HeartbeatInterval()) * time.Second / time.Duration(len(nodes))
+	ticker := time.NewTicker(max(every, time.Millisecond))
+	go func() {
+		defer ticker.Stop()
+		for i := 0; ; i = (i + 1) % len(nodes) {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			nodes[i].heartbeat(reg)
+		}
+	}()
+}
+
+func registerSimNode(reg *Registry, i int) (*simNode, error) {
+	region := simRegs[rand.IntN(len(simRegs))]
+	sn := &simNode{
+		basePowerW:  80 + rand.IntN(120),
+		jobsMax:     1 + rand.IntN(8),
+		gpus:        rand.IntN(3),
+		uptimeStart: time.Now().Add(-time.Duration(rand.IntN(86400)) * time.Second),
+	}
+	req := RegisterRequest{
+		MachineID:    fmt.Sprintf("sim-%05d", i),
+		Hostname:     fmt.Sprintf("sim-%05d", i),
+		IP:           fmt.Sprintf("10.%d.%d.%d", 200+i/65536, i/256%256, i%256),
+		OS:           "linux",
+		Arch:         "x86_64",
+		AgentVersion: "sim",
+		CPU:          CPUInfo{Model: "Simulated CPU", Cores: 4 << rand.IntN(4)},
+		RAMGB:        simRAMGB[rand.IntN(len(simRAMGB))],
+		DiskTotalGB:  500,
+		DiskFreeGB:   100 + rand.IntN(400),
+		Capacity:     Capacity{JobsParallel: sn.jobsMax},
+		Region:       &region,
+		Group:        "simulated",
+		Meta:         map[string]string{"simulated": "true"},
+	}
+	gpu := simGPUs[rand.IntN(len(simGPUs))]
+	for range sn.gpus {
+		req.GPU = append(req.GPU, gpu)
+	}
+	resp, err := registerOne(reg, req, req.IP)
+	if err != nil {
+		return nil, err
+	}
+	sn.id = resp.NodeID
+	return sn, nil
+}
+
+// heartbeat random-walks the job count and derives power from it.
+func (sn *simNode) heartbeat(reg *Registry) {
+	if sn.gone {
+		return
+	}
+	sn.jobs = min(max(sn.jobs+rand.IntN(3)-1, 0), sn.jobsMax)
+	jobs := sn.jobs
+	hb := AgentHeartbeat{
+		NodeID:      sn.id,
+		UptimeSec:   int64(time.Since(sn.uptimeStart).Seconds()),
+		PowerW:      sn.basePowerW + jobs*(40+60*sn.gpus),
+		JobsRunning: &jobs,
+	}
+	for g := range sn.gpus {
+		idx := g
+		hb.GPUs = append(hb.GPUs, GPUUsage{Index: &idx, UtilPct: min(100, jobs*100/sn.jobsMax+rand.IntN(10)), MemUsedMB: jobs * 2048})
+	}
+	if _, ok := reg.Heartbeat(hb); !ok {
+		sn.gone = true
+		log.Printf("simulate: %s was removed, no longer heartbeating it", sn.id)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// ---------- Persistence ----------
+// Registry snapshot on disk (LEGION_STATE_FILE). Empty path disables it.
+
+var stateSaveInterval = 30 * time.Second
+
+// persistedNode adds the fields kept out of the public JSON.
+type persistedNode struct {
+	NodeRecord
+	TokenHash string        `json:"token_hash,omitempty"`
+	Commands  []NodeCommand `json:"commands,omitempty"`
+}
+
+// persistedState is the file layout. Older files are a bare node array.
+type persistedState struct {
+	Nodes      []persistedNode        `json:"nodes"`
+	Usage      map[string][]usageSpan `json:"usage,omitempty"`
+	Quarantine []QuarantineEntry      `json:"quarantine,omitempty"`
+}
+
+func loadState(reg *Registry, path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil // first boot
+	}
+	if err != nil {
+		return err
+	}
+
+	saved, err := parseState(data)
+	if err != nil {
+		// a torn or hand-edited snapshot shouldn't keep the server down;
+		// agents re-register on their next heartbeat anyway
+		bad := fmt.Sprintf("%s.corrupt-%s", path, time.Now().UTC().Format("20060102T150405Z"))
+		if rerr := os.Rename(path, bad); rerr != nil {
+			log.Printf("state file %s is corrupt (%v), starting empty; backup failed: %v", path, err, rerr)
+		} else {
+			log.Printf("state file %s is corrupt (%v), starting empty; moved to %s", path, err, bad)
+		}
+		return nil
+	}
+
+	nodes := make([]NodeRecord, len(saved.Nodes))
+	for i, p := range saved.Nodes {
+		nodes[i] = p.NodeRecord
+		nodes[i].tokenHash = p.TokenHash
+		nodes[i].commands = p.Commands
+	}
+	reg.Restore(nodes)
+	reg.RestoreUsage(saved.Usage)
+	reg.RestoreQuarantine(saved.Quarantine)
+	return nil
+}
+
+func parseState(data []byte) (persistedState, error) {
+	var saved persistedState
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		if err := json.Unmarshal(data, &saved.Nodes); err != nil {
+			return saved, err
+		}
+	} else if err := json.Unmarshal(data, &saved); err != nil {
+		return saved, err
+	}
+	for i, p := range saved.Nodes {
+		if p.NodeID == "" {
+			return saved, fmt.Errorf("entry %d has no node_id", i)
+		}
+	}
+	for i, e := range saved.Quarantine {
+		if e.ID == "" {
+			return saved, fmt.Errorf("quarantine entry %d has no id", i)
+		}
+	}
+	return saved, nil
+}
+
+func saveState(reg *Registry, path string) error {
+	nodes := reg.List(nodeFilter{})
+	saved := persistedState{Nodes: make([]persistedNode, len(nodes)), Usage: reg.Usage(), Quarantine: reg.QuarantineList()}
+	for i, n := range nodes {
+		saved.Nodes[i] = persistedNode{NodeRecord: n, TokenHash: n.tokenHash, Commands: n.commands}
+	}
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// write-then-rename so a crash mid-write never leaves a torn file
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// background: snapshot the registry on a timer
+func startStateSaver(reg *Registry, path string, done <-chan struct{}) {
+	ticker := time.NewTicker(stateSaveInterval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			if err := saveState(reg, path); err != nil {
+				log.Printf("state save failed: %v", err)
+			}
+		}
+	}()
+}
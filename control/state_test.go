@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCorruptStateFileStartsClean(t *testing.T) {
+	for name, data := range map[string]string{
+		"torn":       `[{"node_id": "abc", "hostn`,
+		"no node_id": `[{"hostname": "ghost"}]`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "state.json")
+			if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			reg, _, h := newTestServer(t)
+			if err := loadState(reg, path); err != nil {
+				t.Fatalf("loadState: %v", err)
+			}
+			if nodes := listNodes(t, h); len(nodes) != 0 {
+				t.Fatalf("got %d nodes from a corrupt file, want 0", len(nodes))
+			}
+			if _, err := os.Stat(path); !os.IsNotExist(err) {
+				t.Fatalf("corrupt file left in place: %v", err)
+			}
+			backups, _ := filepath.Glob(path + ".corrupt-*")
+			if len(backups) != 1 {
+				t.Fatalf("got %d backups, want 1", len(backups))
+			}
+
+			// and the next snapshot round-trips normally
+			registerNode(t, h, testRegisterRequest("fresh", "10.0.0.1"))
+			if err := saveState(reg, path); err != nil {
+				t.Fatalf("saveState: %v", err)
+			}
+			again, _, h2 := newTestServer(t)
+			if err := loadState(again, path); err != nil {
+				t.Fatalf("reload: %v", err)
+			}
+			if nodes := listNodes(t, h2); len(nodes) != 1 {
+				t.Fatalf("got %d nodes after reload, want 1", len(nodes))
+			}
+		})
+	}
+}
+
+func TestRestoreKeepsOverriddenStatus(t *testing.T) {
+	reg, _, h := newTestServer(t)
+	pinned := registerNode(t, h, testRegisterRequest("pinned", "10.0.0.1")).NodeID
+	plain := registerNode(t, h, testRegisterRequest("plain", "10.0.0.2")).NodeID
+	if rec := doRequest(t, h, http.MethodPost, "/nodes/"+pinned+"/status", map[string]string{"status": "offline"}, nil); rec.Code != http.StatusOK {
+		t.Fatalf("override: status %d: %s", rec.Code, rec.Body)
+	}
+
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := saveState(reg, path); err != nil {
+		t.Fatalf("saveState: %v", err)
+	}
+	restored, _, h2 := newTestServer(t)
+	if err := loadState(restored, path); err != nil {
+		t.Fatalf("loadState: %v", err)
+	}
+	if n := getNode(t, h2, pinned); n.Status != "offline" || !n.StatusOverride {
+		t.Fatalf("overridden node restored as %q (override %v), want offline pinned", n.Status, n.StatusOverride)
+	}
+	if n := getNode(t, h2, plain); n.Status != "stale" {
+		t.Fatalf("plain node restored as %q, want stale", n.Status)
+	}
+}
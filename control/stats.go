@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// ---------- Lifetime counters ----------
+// Atomics rather than the registry lock so hot paths don't contend.
+var (
+	statRegistrations      atomic.Int64
+	statHeartbeats         atomic.Int64
+	statUnknownNodeRejects atomic.Int64
+)
+
+type ServerStats struct {
+	RegistrationsTotal int64     `json:"registrations_total"`
+	HeartbeatsTotal    int64     `json:"heartbeats_total"`
+	UnknownNodeRejects int64     `json:"unknown_node_rejections_total"`
+	StartTime          time.Time `json:"start_time"`
+	UptimeSec          int64     `json:"uptime_sec"`
+}
+
+// GET /stats
+func statsHandler(reg *Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		if !requireKey(w, r) {
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ServerStats{
+			RegistrationsTotal: statRegistrations.Load(),
+			HeartbeatsTotal:    statHeartbeats.Load(),
+			UnknownNodeRejects: statUnknownNodeRejects.Load(),
+			StartTime:          reg.started,
+			UptimeSec:          int64(reg.Now().Sub(reg.started).Seconds()),
+		})
+	}
+}
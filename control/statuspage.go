@@ -0,0 +1,63 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+)
+
+// ---------- Status page ----------
+// GET / — a zero-dependency HTML view of the fleet for operators.
+var statusPage = template.Must(template.New("status").Parse(`<!doctype html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="{{.RefreshSec}}">
+<title>9th Legion Control</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { padding: 4px 10px; border-bottom: 1px solid #ddd; text-align: left; }
+.online { color: #1a7f37; } .draining { color: #9a6700; }
+.stale { color: #bc4c00; } .offline { color: #cf222e; }
+</style>
+</head>
+<body>
+<h1>9th Legion Control</h1>
+<p>{{len .Nodes}} nodes as of {{.Now.Format "2006-01-02 15:04:05 MST"}}</p>
+<table>
+<tr><th>Hostname</th><th>Node ID</th><th>Status</th><th>IP</th><th>Group</th><th>Region</th><th>Jobs</th><th>Power (W)</th><th>Last seen</th></tr>
+{{range .Nodes}}<tr>
+<td>{{.Hostname}}</td><td><code>{{.NodeID}}</code></td>
+<td class="{{.Status}}">{{.Status}}{{if .Maintenance}} (maintenance){{end}}</td>
+<td>{{.ReportedIP}}</td><td>{{.Group}}</td><td>{{.Region}}</td>
+<td>{{.JobsRunning}}/{{.Capacity.JobsParallel}}</td><td>{{.PowerW}}</td>
+<td>{{.LastSeenAgeSec}}s ago</td>
+</tr>{{end}}
+</table>
+</body>
+</html>
+`))
+
+func statusPageHandler(reg *Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		if !requireKey(w, r) {
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err := statusPage.Execute(w, struct {
+			Nodes      []NodeRecord
+			Now        time.Time
+			RefreshSec int
+		}{reg.List(nodeFilter{}), reg.Now(), 10})
+		if err != nil {
+			log.Printf("status page: %v", err)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ---------- WebSocket stream ----------
+// GET /nodes/stream: one snapshot message, then a NodeEvent per change.
+const (
+	streamWriteWait  = 10 * time.Second
+	streamPongWait   = 60 * time.Second
+	streamPingPeriod = streamPongWait * 9 / 10
+)
+
+var upgrader = websocket.Upgrader{}
+
+func streamNodesHandler(reg *Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		if !requireKey(w, r) {
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return // Upgrade already wrote the error response
+		}
+		defer conn.Close()
+
+		snapshot, events, cancel := reg.Subscribe()
+		defer cancel()
+
+		// reader: we don't expect client messages, but must read to see
+		// pongs and the close frame
+		gone := make(chan struct{})
+		conn.SetReadDeadline(time.Now().Add(streamPongWait))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(streamPongWait))
+		})
+		go func() {
+			defer close(gone)
+			for {
+				if _, _, err := conn.NextReader(); err != nil {
+					return
+				}
+			}
+		}()
+
+		send := func(ev NodeEvent) bool {
+			conn.SetWriteDeadline(time.Now().Add(streamWriteWait))
+			return conn.WriteJSON(ev) == nil
+		}
+
+		if !send(NodeEvent{Type: "snapshot", Nodes: snapshot}) {
+			return
+		}
+
+		ping := time.NewTicker(streamPingPeriod)
+		defer ping.Stop()
+		for {
+			select {
+			case <-gone:
+				return
+			case ev, ok := <-events:
+				if !ok {
+					// dropped for being slow, or server shutting down
+					conn.WriteControl(websocket.CloseMessage,
+						websocket.FormatCloseMessage(websocket.CloseGoingAway, ""),
+						time.Now().Add(streamWriteWait))
+					return
+				}
+				if !send(ev) {
+					return
+				}
+			case <-ping.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteWait)); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
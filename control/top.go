@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// ---------- Top consumers ----------
+const defaultTopN = 10
+
+// TopByPower returns up to n nodes matching f with the highest PowerW,
+// ties broken by NodeID so repeated calls agree.
+func (r *Registry) TopByPower(f nodeFilter, n int) []NodeRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	nodes := r.listLocked(f) // already in NodeID order
+	sort.SliceStable(nodes, func(i, j int) bool { return nodes[i].PowerW > nodes[j].PowerW })
+	return nodes[:min(n, len(nodes))]
+}
+
+// GET /nodes/top?by=power&n=10, plus any /nodes filter
+func topNodesHandler(reg *Registry) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		scope, ok := requireListKey(w, r)
+		if !ok {
+			return
+		}
+
+		q := r.URL.Query()
+		if by := q.Get("by"); by != "" && by != "power" {
+			writeError(w, http.StatusBadRequest, "bad_request", "by must be power")
+			return
+		}
+		n := defaultTopN
+		if q.Has("n") {
+			var err error
+			if n, err = queryInt(q, "n"); err != nil || n == 0 || n > maxPageLimit {
+				writeError(w, http.StatusBadRequest, "bad_request", fmt.Sprintf("n must be 1..%d", maxPageLimit))
+				return
+			}
+		}
+		filter, err := parseNodeFilter(q)
+		if err != nil {
+			writeErrorFor(w, http.StatusBadRequest, err)
+			return
+		}
+		filter.LabelPrefixes = scope
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(reg.TopByPower(filter, n))
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ---------- Validation ----------
+const (
+	maxMetaEntries  = 32
+	maxMetaKeyLen   = 64
+	maxMetaValueLen = 256
+	maxLabels       = 32
+	maxLabelLen     = 63
+)
+
+// knownRuntimes are the container runtimes agents may report; anything
+// else goes in as "other" (with specifics in meta if needed), see
+// registerRuntime.
+var knownRuntimes = []string{"docker", "containerd", "podman", "cri-o", "other"}
+
+func normalizeRuntime(s string) string {
+	return strings.ToLower(strings.TrimSpace(s))
+}
+
+func registerRuntime(s string) string {
+	rt := normalizeRuntime(s)
+	if rt != "" && !slices.Contains(knownRuntimes, rt) {
+		return "other"
+	}
+	return rt
+}
+
+// ValidationError lists every offending field, not just the first.
+type ValidationError struct {
+	Fields []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid fields: " + strings.Join(e.Fields, "; ")
+}
+
+func (e *ValidationError) add(msg string) {
+	e.Fields = append(e.Fields, msg)
+}
+
+func (e *ValidationError) orNil() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (req RegisterRequest) Validate() error {
+	var ve ValidationError
+	if strings.TrimSpace(req.Hostname) == "" {
+		ve.add("hostname: required")
+	}
+	if strings.TrimSpace(req.OS) == "" {
+		ve.add("os: required")
+	}
+	if strings.TrimSpace(req.Arch) == "" {
+		ve.add("arch: required")
+	}
+	if req.CPU.Cores < 0 {
+		ve.add("cpu.cores: must be >= 0")
+	}
+	if req.RAMGB < 0 {
+		ve.add("ram_gb: must be >= 0")
+	}
+	if req.DiskTotalGB < 0 {
+		ve.add("disk_total_gb: must be >= 0")
+	}
+	if req.DiskFreeGB < 0 || (req.DiskTotalGB > 0 && req.DiskFreeGB > req.DiskTotalGB) {
+		ve.add("disk_free_gb: must be between 0 and disk_total_gb")
+	}
+	if req.Capacity.JobsParallel < 0 {
+		ve.add("capacity.jobs_parallel: must be >= 0")
+	}
+	if req.Region != nil && strings.TrimSpace(*req.Region) == "" {
+		ve.add("region: must be non-empty when present")
+	}
+	if req.PricePerHour < 0 {
+		ve.add("price_per_hour: must be >= 0")
+	}
+	validateLabels("labels", normalizeLabels(req.Labels), &ve)
+	validateMeta(req.Meta, &ve)
+	return ve.orNil()
+}
+
+func validateMeta(meta map[string]string, ve *ValidationError) {
+	if len(meta) > maxMetaEntries {
+		ve.add(fmt.Sprintf("meta: at most %d entries", maxMetaEntries))
+	}
+	for k, v := range meta {
+		if k == "" || len(k) > maxMetaKeyLen {
+			ve.add(fmt.Sprintf("meta: key %.16q must be 1..%d bytes", k, maxMetaKeyLen))
+		}
+		if len(v) > maxMetaValueLen {
+			ve.add(fmt.Sprintf("meta.%.16s: value longer than %d bytes", k, maxMetaValueLen))
+		}
+	}
+}
+
+// normalizeLabels trims and lowercases labels and drops duplicates,
+// keeping first-seen order.
+func normalizeLabels(labels []string) []string {
+	if labels == nil {
+		return nil
+	}
+	out := make([]string, 0, len(labels))
+	for _, l := range labels {
+		l = strings.ToLower(strings.TrimSpace(l))
+		if !slices.Contains(out, l) {
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+// validateLabels checks already-normalized labels: at most maxLabels,
+// each 1..maxLabelLen bytes of [a-z0-9.-/].
+func validateLabels(field string, labels []string, ve *ValidationError) {
+	if len(labels) > maxLabels {
+		ve.add(fmt.Sprintf("%s: at most %d labels", field, maxLabels))
+	}
+	for _, l := range labels {
+		if l == "" || len(l) > maxLabelLen {
+			ve.add(fmt.Sprintf("%s: %.16q must be 1..%d bytes", field, l, maxLabelLen))
+			continue
+		}
+		if strings.IndexFunc(l, func(c rune) bool {
+			return !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '.' || c == '/')
+		}) >= 0 {
+			ve.add(fmt.Sprintf("%s: %.16q may only contain a-z, 0-9, '-', '.' and '/'", field, l))
+		}
+	}
+}
+
+// normalizeReportedIP returns the canonical form of an agent-reported IP.
+// Missing or loopback values fall back to the public IP we observed;
+// hostnames and unspecified addresses (0.0.0.0, ::) are rejected.
+func normalizeReportedIP(reported, publicIP string) (string, error) {
+	reported = strings.TrimSpace(reported)
+	if reported == "" {
+		return publicIP, nil
+	}
+	ip := parseHostIP(reported)
+	if ip == nil {
+		return "", fmt.Errorf("ip: %q is not an IP address", reported)
+	}
+	if ip.IsUnspecified() {
+		return "", fmt.Errorf("ip: %s is not a routable address", reported)
+	}
+	if ip.IsLoopback() {
+		return publicIP, nil
+	}
+	return ip.String(), nil
+}
+
+// ---------- Hardware fingerprint ----------
+// Fingerprint hashes CPU model, core count and MAC into a short stable ID.
+// Empty when the agent sent none of them.
+func (req RegisterRequest) Fingerprint() string {
+	model := strings.TrimSpace(req.CPU.Model)
+	mac := strings.ToLower(strings.NewReplacer("-", "", ":", "", ".", "").Replace(strings.TrimSpace(req.MAC)))
+	if model == "" && req.CPU.Cores == 0 && mac == "" {
+		return ""
+	}
+	sum := sha256.Sum256(fmt.Appendf(nil, "%s|%d|%s", model, req.CPU.Cores, mac))
+	return hex.EncodeToString(sum[:8])
+}
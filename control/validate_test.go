@@ -0,0 +1,18 @@
+package main
+
+import "testing"
+
+func TestUnknownRuntimeRegistersAsOther(t *testing.T) {
+	_, _, h := newTestServer(t)
+	for host, rt := range map[string]string{"a": "Docker ", "b": "gvisor", "c": ""} {
+		req := testRegisterRequest(host, "10.0.0.1")
+		req.Runtime = rt
+		registerNode(t, h, req)
+	}
+	want := map[string]string{"a": "docker", "b": "other", "c": ""}
+	for _, n := range listNodes(t, h) {
+		if n.Runtime != want[n.Hostname] {
+			t.Errorf("%s: runtime %q, want %q", n.Hostname, n.Runtime, want[n.Hostname])
+		}
+	}
+}
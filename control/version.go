@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime/debug"
+)
+
+// ---------- Build info ----------
+// Set at build time, e.g.
+//
+//	go build -ldflags "-X main.version=1.4.0 -X main.commit=$(git rev-parse --short HEAD) -X main.buildDate=$(date -u +%FT%TZ)" ./control
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
+
+type VersionInfo struct {
+	Version              string `json:"version"`
+	Commit               string `json:"commit,omitempty"`
+	BuildDate            string `json:"build_date,omitempty"`
+	GoVersion            string `json:"go_version"`
+	HeartbeatIntervalSec int    `json:"heartbeat_interval_sec"`
+	AuthEnabled          bool   `json:"auth_enabled"`
+}
+
+// buildInfo fills in anything ldflags didn't from the toolchain's own
+// VCS stamping, so plain `go build` in a checkout still names a commit.
+func buildInfo() VersionInfo {
+	v := VersionInfo{Version: version, Commit: commit, BuildDate: buildDate}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		v.GoVersion = bi.GoVersion
+		for _, s := range bi.Settings {
+			switch {
+			case s.Key == "vcs.revision" && v.Commit == "":
+				v.Commit = s.Value
+			case s.Key == "vcs.time" && v.BuildDate == "":
+				v.BuildDate = s.Value
+			}
+		}
+	}
+	return v
+}
+
+// GET /version
+func versionHandler(reg *Registry) http.HandlerFunc {
+	info := buildInfo()
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		if !requireKey(w, r) {
+			return
+		}
+		info.HeartbeatIntervalSec = reg.HeartbeatInterval()
+		info.AuthEnabled = apiKey != ""
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(info)
+	}
+}